@@ -5,6 +5,43 @@ export let token = ""
 export let uuid = ""
 export let client = ky.create({})
 
+type PowChallenge = { challenge: string; difficulty: number }
+
+function leadingZeroBits(bytes: Uint8Array) {
+    let count = 0
+
+    for (const byte of bytes) {
+        if (byte !== 0) return count + Math.clz32(byte) - 24
+
+        count += 8
+    }
+
+    return count
+}
+
+// Solves the server's proof of work challenge, result goes to 'X-Pow' header
+async function solvePow() {
+    const { challenge, difficulty } = await ky
+        .get(`/api/challenge`)
+        .json<PowChallenge>()
+
+    if (difficulty === 0) return ""
+
+    const encoder = new TextEncoder()
+
+    for (let i = 0; ; i++) {
+        const solution = `${challenge}:${i}`
+        const digest = await crypto.subtle.digest(
+            "SHA-256",
+            encoder.encode(solution),
+        )
+
+        if (leadingZeroBits(new Uint8Array(digest)) >= difficulty) {
+            return solution
+        }
+    }
+}
+
 export type GetPostsResp = { posts: D.Post[]; nextOffset: number }
 export type GetCommentsResp = { comments: D.Comment[]; nextOffset: number }
 
@@ -27,7 +64,10 @@ export async function getPosts(
 }
 
 export async function createPost(content: string) {
-    await client.post(`posts`, { json: { content } })
+    await client.post(`posts`, {
+        json: { content },
+        headers: { "X-Pow": await solvePow() },
+    })
 }
 
 export async function likePost(postId: number) {
@@ -80,6 +120,7 @@ export async function createComment({
 
     await client.post(`posts/${postId}/comments`, {
         json: body,
+        headers: { "X-Pow": await solvePow() },
     })
 }
 
@@ -94,22 +135,52 @@ export async function unlikeComment(commentId: number) {
 type AuthResp = {
     uuid: string
     token: string
+    refreshToken: string
+}
+
+let refreshToken = ""
+
+async function refresh() {
+    const json = await ky
+        .post(`api/auth/refresh`, { json: { refreshToken } })
+        .json<AuthResp>()
+
+    token = json.token
+    refreshToken = json.refreshToken
 }
 
 export async function createAPI() {
-    const resp = await client.post(`api/auth`)
+    const resp = await client.post(`api/auth`, {
+        headers: { "X-Pow": await solvePow() },
+    })
 
     const json = await resp.json<AuthResp>()
 
     uuid = json.uuid
     token = json.token
+    refreshToken = json.refreshToken
 
     client = ky.create({
         retry: 0,
         throwHttpErrors: true,
         prefixUrl: "/api",
-        headers: {
-            Authorization: token,
+        hooks: {
+            beforeRequest: [
+                (request) => {
+                    request.headers.set("Authorization", token)
+                },
+            ],
+            afterResponse: [
+                async (request, _options, response) => {
+                    if (response.status !== 401) return
+
+                    await refresh()
+
+                    request.headers.set("Authorization", token)
+
+                    return ky(request)
+                },
+            ],
         },
     })
 }
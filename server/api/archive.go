@@ -0,0 +1,58 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Threads older than ARCHIVE_AFTER, or without new comments for ARCHIVE_INACTIVE_AFTER,
+// become read only and move to the archive listing. Zero turns a policy off.
+var archiveAfter = envDuration("ARCHIVE_AFTER", 0)
+var archiveInactiveAfter = envDuration("ARCHIVE_INACTIVE_AFTER", 0)
+var archiveInterval = envDuration("ARCHIVE_INTERVAL", time.Hour)
+
+func StartArchive() {
+	if archiveAfter <= 0 && archiveInactiveAfter <= 0 {
+		return
+	}
+
+	go func() {
+		for range time.Tick(archiveInterval) {
+			if err := archive(context.Background()); err != nil {
+				log.Println(fmt.Sprintf("Archive failed: %s", err))
+			}
+		}
+	}()
+}
+
+func archive(ctx context.Context) error {
+	var params sqlc.ArchivePostsParams
+
+	now := time.Now()
+
+	if archiveAfter > 0 {
+		params.CreatedBefore = pgtype.Timestamptz{Time: now.Add(-archiveAfter), Valid: true}
+	}
+
+	if archiveInactiveAfter > 0 {
+		params.ActiveBefore = pgtype.Timestamptz{Time: now.Add(-archiveInactiveAfter), Valid: true}
+	}
+
+	archived, err := db.Query.ArchivePosts(ctx, params)
+
+	if err != nil {
+		return err
+	}
+
+	if archived > 0 {
+		log.Println(fmt.Sprintf("Archived %d posts", archived))
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"snakesss/db"
+	"snakesss/media"
+	"snakesss/sqlc"
+	"snakesss/storage"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Posts and comments take one image, sent as multipart/form-data with the json
+// body in the 'payload' field and the image in the 'file' field. Routes with
+// UploadSizeMiddleware accept bodies up to UPLOAD_MAX_SIZE.
+var uploadMaxSize = int64(envInt("UPLOAD_MAX_SIZE", 8*1024*1024))
+var uploadMaxPixels = envInt("UPLOAD_MAX_PIXELS", 25_000_000)
+var uploadThumbSize = envInt("UPLOAD_THUMB_SIZE", 250)
+
+var files storage.Storage = loadStorage()
+
+func loadStorage() storage.Storage {
+	dir := os.Getenv("UPLOAD_DIR")
+
+	if dir == "" {
+		dir = "uploads"
+	}
+
+	local, err := storage.NewLocal(dir)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return local
+}
+
+// Decodes json or multipart body into dst, returns the uploaded file, nil when there is none
+func decodeBody(r *http.Request, dst any) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType != "multipart/form-data" {
+		return nil, json.NewDecoder(r.Body).Decode(dst)
+	}
+
+	err := r.ParseMultipartForm(uploadMaxSize)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.MultipartForm.RemoveAll()
+
+	err = json.Unmarshal([]byte(r.FormValue("payload")), dst)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, _, err := r.FormFile("file")
+
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return io.ReadAll(file)
+}
+
+// Fails the request with the reason the body could not be read
+func failBody(w http.ResponseWriter, requestId string, err error) {
+	var maxBytesErr *http.MaxBytesError
+
+	if errors.As(err, &maxBytesErr) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     fmt.Errorf("Body is larger than %d bytes", maxBytesErr.Limit),
+			cause:     err,
+			Code:      413,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	errReq := RequestError{
+		RequestId: requestId,
+		error:     errors.New("Body is invalid"),
+		cause:     err,
+		Code:      400,
+	}
+	fail(w, errReq)
+}
+
+func extension(contentType string) string {
+	return "." + strings.TrimPrefix(contentType, "image/")
+}
+
+// Strips the image, makes its thumbnail and stores both, unless the same image is already stored
+func saveAttachment(ctx context.Context, data []byte) (sqlc.Attachment, error) {
+	image, err := media.Process(data, uploadMaxPixels, uploadThumbSize)
+
+	if err != nil {
+		return sqlc.Attachment{}, err
+	}
+
+	sum := sha256.Sum256(image.Data)
+	hash := hex.EncodeToString(sum[:])
+
+	attachment, err := db.Query.GetAttachment(ctx, hash)
+
+	if err == nil {
+		return attachment, nil
+	}
+
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return attachment, err
+	}
+
+	params := sqlc.CreateAttachmentParams{
+		Hash:        hash,
+		ContentType: image.ContentType,
+		Size:        int32(len(image.Data)),
+		Width:       int32(image.Width),
+		Height:      int32(image.Height),
+		FileKey:     hash + extension(image.ContentType),
+		ThumbKey:    hash + "_thumb" + extension(image.ThumbContentType),
+		ThumbWidth:  int32(image.ThumbWidth),
+		ThumbHeight: int32(image.ThumbHeight),
+	}
+
+	// Files go first, so a row never points to a missing file
+	err = files.Put(ctx, params.FileKey, image.Data)
+
+	if err != nil {
+		return attachment, err
+	}
+
+	err = files.Put(ctx, params.ThumbKey, image.Thumb)
+
+	if err != nil {
+		return attachment, err
+	}
+
+	return db.Query.CreateAttachment(ctx, params)
+}
+
+func attachmentHash(attachment *sqlc.Attachment) pgtype.Text {
+	if attachment == nil {
+		return pgtype.Text{}
+	}
+
+	return pgtype.Text{String: attachment.Hash, Valid: true}
+}
+
+// Fails the request with the reason the attachment could not be saved
+func failAttachment(w http.ResponseWriter, requestId string, err error) {
+	switch {
+	case errors.Is(err, media.ErrUnsupported):
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     err,
+			cause:     errors.New("Unsupported media type"),
+			Code:      415,
+		}
+		fail(w, errReq)
+	case errors.Is(err, media.ErrTooLarge):
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     fmt.Errorf("Image is larger than %d pixels", uploadMaxPixels),
+			cause:     err,
+			Code:      413,
+		}
+		fail(w, errReq)
+	default:
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+	}
+}
+
+// Files never change, their keys are content hashes, but they stop being served
+// once everything they are attached to is deleted
+func GetFile(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched GetFile route"))
+
+	key := chi.URLParam(r, "key")
+
+	served, err := db.Query.IsFileServed(r.Context(), key)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if !served {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("File not found"),
+			cause:     errors.New("Not found"),
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	file, modTime, err := files.Open(r.Context(), key)
+
+	if errors.Is(err, storage.ErrNotFound) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("File not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	defer file.Close()
+
+	ext := filepath.Ext(key)
+	contentType := mime.TypeByExtension(ext)
+
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Short enough for deletions to take effect in caches
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Header().Set("ETag", strconv.Quote(strings.TrimSuffix(key, ext)))
+
+	http.ServeContent(w, r, key, modTime, file)
+	requestLog(requestId, fmt.Sprintf("Request successful, file: %s", key))
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	modActionDeletePost     = "delete_post"
+	modActionRestorePost    = "restore_post"
+	modActionApprovePost    = "approve_post"
+	modActionLockPost       = "lock_post"
+	modActionUnlockPost     = "unlock_post"
+	modActionPinPost        = "pin_post"
+	modActionUnpinPost      = "unpin_post"
+	modActionDeleteComment  = "delete_comment"
+	modActionRestoreComment = "restore_comment"
+	modActionApproveComment = "approve_comment"
+	modActionBan            = "ban"
+	modActionLiftBan        = "lift_ban"
+	modActionSetRole        = "set_role"
+	modActionShadowban      = "shadowban"
+	modActionRevokeTokens   = "revoke_tokens"
+	modActionResolveReports = "resolve_reports"
+	modActionSetBoard       = "set_board"
+)
+
+const (
+	targetTypePost    = "post"
+	targetTypeComment = "comment"
+	targetTypeAuthor  = "author"
+	targetTypeBan     = "ban"
+	targetTypeBoard   = "board"
+)
+
+const modActionsPerLoad = 50
+
+// Before and After are rows of the target, nil when there is nothing to snapshot
+type modAction struct {
+	Action     string
+	TargetType string
+	TargetId   string
+	Reason     string
+	Before     any
+	After      any
+}
+
+func snapshot(row any) (json.RawMessage, error) {
+	if row == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(row)
+}
+
+func logModAction(ctx context.Context, q *sqlc.Queries, moderator uuid.UUID, action modAction) error {
+	before, err := snapshot(action.Before)
+
+	if err != nil {
+		return err
+	}
+
+	after, err := snapshot(action.After)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = q.CreateModAction(ctx, sqlc.CreateModActionParams{
+		Moderator:  moderator,
+		Action:     action.Action,
+		TargetType: action.TargetType,
+		TargetID:   action.TargetId,
+		Reason:     pgtype.Text{String: action.Reason, Valid: action.Reason != ""},
+		Before:     before,
+		After:      after,
+	})
+
+	return err
+}
+
+// Runs the action and writes it to the log in one transaction, so nothing is done without a record
+func inModTx(ctx context.Context, moderator uuid.UUID, fn func(qtx *sqlc.Queries) (modAction, error)) error {
+	tx, err := db.Pool.Begin(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	qtx := db.Query.WithTx(tx)
+
+	action, err := fn(qtx)
+
+	if err != nil {
+		return err
+	}
+
+	err = logModAction(ctx, qtx, moderator, action)
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Change returns pgx.ErrNoRows when there was nothing to change
+func auditPost(ctx context.Context, moderator uuid.UUID, postId int32, action string, reason string, change func(qtx *sqlc.Queries) error) error {
+	return inModTx(ctx, moderator, func(qtx *sqlc.Queries) (modAction, error) {
+		before, err := qtx.GetPostById(ctx, postId)
+
+		if err != nil {
+			return modAction{}, err
+		}
+
+		err = change(qtx)
+
+		if err != nil {
+			return modAction{}, err
+		}
+
+		after, err := qtx.GetPostById(ctx, postId)
+
+		return modAction{
+			Action:     action,
+			TargetType: targetTypePost,
+			TargetId:   strconv.Itoa(int(postId)),
+			Reason:     reason,
+			Before:     before,
+			After:      after,
+		}, err
+	})
+}
+
+func auditComment(ctx context.Context, moderator uuid.UUID, commentId int32, action string, reason string, change func(qtx *sqlc.Queries) error) error {
+	return inModTx(ctx, moderator, func(qtx *sqlc.Queries) (modAction, error) {
+		before, err := qtx.GetCommentById(ctx, commentId)
+
+		if err != nil {
+			return modAction{}, err
+		}
+
+		err = change(qtx)
+
+		if err != nil {
+			return modAction{}, err
+		}
+
+		after, err := qtx.GetCommentById(ctx, commentId)
+
+		return modAction{
+			Action:     action,
+			TargetType: targetTypeComment,
+			TargetId:   strconv.Itoa(int(commentId)),
+			Reason:     reason,
+			Before:     before,
+			After:      after,
+		}, err
+	})
+}
+
+// Optional reason of routes without a body
+func modReason(r *http.Request) string {
+	return r.URL.Query().Get("reason")
+}
+
+func GetModActions(w http.ResponseWriter, r *http.Request) {
+	type GetModActionsResp struct {
+		NextOffset *int             `json:"nextOffset"`
+		Actions    []sqlc.ModAction `json:"actions"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched GetModActions route"))
+
+	query := r.URL.Query()
+
+	params := sqlc.GetModActionsParams{
+		Limit:      modActionsPerLoad,
+		TargetType: pgtype.Text{String: query.Get("targetType"), Valid: query.Get("targetType") != ""},
+		TargetID:   pgtype.Text{String: query.Get("targetId"), Valid: query.Get("targetId") != ""},
+	}
+
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("'offset' is not a number"),
+				cause:     errors.New("Bad request"),
+				Code:      400,
+			}
+			fail(w, errReq)
+			return
+		}
+
+		params.Offset = int32(offset)
+	}
+
+	if moderatorStr := query.Get("moderator"); moderatorStr != "" {
+		moderator, err := uuid.FromString(moderatorStr)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("'moderator' is not a uuid"),
+				cause:     errors.New("Bad request"),
+				Code:      400,
+			}
+			fail(w, errReq)
+			return
+		}
+
+		params.Moderator = pgtype.UUID{Bytes: moderator, Valid: true}
+	}
+
+	for name, param := range map[string]*pgtype.Timestamptz{"from": &params.CreatedAfter, "to": &params.CreatedBefore} {
+		str := query.Get(name)
+
+		if str == "" {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, str)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     fmt.Errorf("'%s' is not an RFC 3339 time", name),
+				cause:     err,
+				Code:      400,
+			}
+			fail(w, errReq)
+			return
+		}
+
+		*param = pgtype.Timestamptz{Time: t, Valid: true}
+	}
+
+	actions, err := db.Query.GetModActions(r.Context(), params)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	var nextOffset *int
+
+	if len(actions) >= modActionsPerLoad {
+		temp := int(params.Offset) + modActionsPerLoad
+		nextOffset = &temp
+	}
+
+	resp := GetModActionsResp{
+		NextOffset: nextOffset,
+		Actions:    actions,
+	}
+
+	if resp.Actions == nil {
+		resp.Actions = make([]sqlc.ModAction, 0)
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", 200))
+}
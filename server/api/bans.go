@@ -0,0 +1,371 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const bansPerLoad = 50
+
+// Returns the active ban matching author or ip, nil if there is none
+// Returned inside transactions to roll them back when the author turns out to be banned
+var errBanned = errors.New("Author is banned")
+
+func activeBan(ctx context.Context, author uuid.UUID, ip netip.Addr) (*sqlc.Ban, error) {
+	ban, err := db.Query.GetActiveBan(ctx, sqlc.GetActiveBanParams{
+		Author: pgtype.UUID{Bytes: author, Valid: true},
+		Ip:     ip,
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &ban, nil
+}
+
+// Same as fail, but tells the client why and until when it is banned
+func failBanned(w http.ResponseWriter, requestId string, ban *sqlc.Ban) {
+	type BanInfo struct {
+		ID        int32              `json:"id"`
+		Reason    string             `json:"reason"`
+		ExpiresAt pgtype.Timestamptz `json:"expiresAt"`
+	}
+	type BannedResp struct {
+		Error string  `json:"error"`
+		Ban   BanInfo `json:"ban"`
+	}
+
+	resp := BannedResp{
+		Error: "You are banned",
+		Ban: BanInfo{
+			ID:        ban.ID,
+			Reason:    ban.Reason,
+			ExpiresAt: ban.ExpiresAt,
+		},
+	}
+
+	marshResp, _ := json.Marshal(resp)
+
+	requestLog(requestId, fmt.Sprintf("Request failed, status: %d, ban: %d", 403, ban.ID))
+	w.WriteHeader(403)
+	w.Write(marshResp)
+}
+
+func parseBanNetwork(str string) (netip.Prefix, error) {
+	if strings.Contains(str, "/") {
+		prefix, err := netip.ParsePrefix(str)
+
+		return prefix.Masked(), err
+	}
+
+	addr, err := netip.ParseAddr(str)
+
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	addr = addr.Unmap()
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+func CreateBan(w http.ResponseWriter, r *http.Request) {
+	type CreateBanReq struct {
+		Author    *uuid.UUID `json:"author"`
+		Ip        string     `json:"ip"` // Single address or CIDR range
+		Reason    string     `json:"reason"`
+		ExpiresAt *time.Time `json:"expiresAt"` // Permanent ban when empty
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched CreateBan route"))
+
+	var req CreateBanReq
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if (req.Author == nil) == (req.Ip == "") {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Either 'author' or 'ip' must be provided"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if strings.TrimSpace(req.Reason) == "" {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'reason' is empty"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	params := sqlc.CreateBanParams{
+		Reason:    strings.TrimSpace(req.Reason),
+		CreatedBy: moderator,
+	}
+
+	if req.Author != nil {
+		params.Author = pgtype.UUID{Bytes: *req.Author, Valid: true}
+	} else {
+		network, err := parseBanNetwork(req.Ip)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("'ip' is not an address or CIDR range"),
+				cause:     err,
+				Code:      400,
+			}
+			fail(w, errReq)
+			return
+		}
+
+		params.Network = &network
+	}
+
+	if req.ExpiresAt != nil {
+		params.ExpiresAt = pgtype.Timestamptz{Time: *req.ExpiresAt, Valid: true}
+	}
+
+	var ban sqlc.Ban
+
+	err = inModTx(r.Context(), moderator, func(qtx *sqlc.Queries) (modAction, error) {
+		ban, err = qtx.CreateBan(r.Context(), params)
+
+		return modAction{
+			Action:     modActionBan,
+			TargetType: targetTypeBan,
+			TargetId:   strconv.Itoa(int(ban.ID)),
+			Reason:     ban.Reason,
+			After:      ban,
+		}, err
+	})
+
+	// Foreign key violation, there is no such author
+	if isPgError(err, "23503") {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Author not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	marshResp, err := json.Marshal(ban)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, response: %s", 200, marshResp))
+}
+
+func GetBans(w http.ResponseWriter, r *http.Request) {
+	type GetBansResp struct {
+		NextOffset *int       `json:"nextOffset"`
+		Bans       []sqlc.Ban `json:"bans"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched GetBans route"))
+
+	offsetStr := r.URL.Query().Get("offset")
+
+	var offset int32
+
+	if offsetStr != "" {
+		offset64, err := strconv.Atoi(offsetStr)
+
+		offset = int32(offset64)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("'offset' is not a number"),
+				cause:     errors.New("Bad request"),
+				Code:      400,
+			}
+			fail(w, errReq)
+			return
+		}
+	}
+
+	bans, err := db.Query.GetBans(r.Context(), sqlc.GetBansParams{
+		Limit:  bansPerLoad,
+		Offset: offset,
+		Active: r.URL.Query().Get("active") != "false",
+	})
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	var nextOffset *int
+
+	if len(bans) >= bansPerLoad {
+		temp := int(offset) + bansPerLoad
+		nextOffset = &temp
+	}
+
+	resp := GetBansResp{
+		NextOffset: nextOffset,
+		Bans:       bans,
+	}
+
+	if resp.Bans == nil {
+		resp.Bans = make([]sqlc.Ban, 0)
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", 200))
+}
+
+func LiftBan(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched LiftBan route"))
+
+	banIdStr := chi.URLParam(r, "banId")
+
+	banId, err := strconv.Atoi(banIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'banId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	err = inModTx(r.Context(), moderator, func(qtx *sqlc.Queries) (modAction, error) {
+		before, err := qtx.GetBan(r.Context(), int32(banId))
+
+		if err != nil {
+			return modAction{}, err
+		}
+
+		after, err := qtx.LiftBan(r.Context(), sqlc.LiftBanParams{
+			ID:       int32(banId),
+			LiftedBy: pgtype.UUID{Bytes: moderator, Valid: true},
+		})
+
+		return modAction{
+			Action:     modActionLiftBan,
+			TargetType: targetTypeBan,
+			TargetId:   strconv.Itoa(banId),
+			Reason:     modReason(r),
+			Before:     before,
+			After:      after,
+		}, err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Ban not found or already lifted"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Board of posts created on /posts, without a board in the path
+var defaultBoard = loadDefaultBoard()
+
+var boardSlugRegexp = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// Stored as json in board.settings
+type boardSettings struct {
+	ReadOnly bool `json:"readOnly"` // Only moderators start threads
+}
+
+func loadDefaultBoard() string {
+	if slug := os.Getenv("DEFAULT_BOARD"); slug != "" {
+		return slug
+	}
+
+	return "b"
+}
+
+func parseBoardSettings(board sqlc.Board) boardSettings {
+	var settings boardSettings
+
+	err := json.Unmarshal(board.Settings, &settings)
+
+	if err != nil {
+		log.Println(fmt.Sprintf("Board '%s' has invalid settings, using defaults: %s", board.Slug, err))
+	}
+
+	return settings
+}
+
+// Fails the request when the board in the path does not exist
+func boardFromPath(w http.ResponseWriter, r *http.Request) (sqlc.Board, bool) {
+	requestId := r.Context().Value("requestId").(string)
+
+	board, err := db.Query.GetBoard(r.Context(), chi.URLParam(r, "slug"))
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Board not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return board, false
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return board, false
+	}
+
+	return board, true
+}
+
+func GetBoards(w http.ResponseWriter, r *http.Request) {
+	type GetBoardsResp struct {
+		Boards []sqlc.Board `json:"boards"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched GetBoards route"))
+
+	boards, err := db.Query.GetBoards(r.Context())
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	resp := GetBoardsResp{
+		Boards: boards,
+	}
+
+	if resp.Boards == nil {
+		resp.Boards = make([]sqlc.Board, 0)
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", 200))
+}
+
+func GetBoardPosts(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched GetBoardPosts route"))
+
+	board, ok := boardFromPath(w, r)
+
+	if !ok {
+		return
+	}
+
+	getPosts(w, r, false, pgtype.Int4{Int32: board.ID, Valid: true})
+}
+
+func GetBoardArchivedPosts(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched GetBoardArchivedPosts route"))
+
+	board, ok := boardFromPath(w, r)
+
+	if !ok {
+		return
+	}
+
+	getPosts(w, r, true, pgtype.Int4{Int32: board.ID, Valid: true})
+}
+
+func CreateBoardPost(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched CreateBoardPost route"))
+
+	board, ok := boardFromPath(w, r)
+
+	if !ok {
+		return
+	}
+
+	createPost(w, r, board)
+}
+
+// Creates the board or replaces title, description and settings of an existing one
+func SetBoard(w http.ResponseWriter, r *http.Request) {
+	type SetBoardReq struct {
+		Title       string        `json:"title"`
+		Description string        `json:"description"`
+		Settings    boardSettings `json:"settings"`
+		Reason      string        `json:"reason"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched SetBoard route"))
+
+	slug := chi.URLParam(r, "slug")
+
+	if !boardSlugRegexp.MatchString(slug) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'slug' must be 1 to 32 lowercase letters, digits or '_'"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	var req SetBoardReq
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if strings.TrimSpace(req.Title) == "" {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'title' is empty"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	settings, err := json.Marshal(req.Settings)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	var board sqlc.Board
+
+	err = inModTx(r.Context(), moderator, func(qtx *sqlc.Queries) (modAction, error) {
+		var before any
+
+		existing, err := qtx.GetBoard(r.Context(), slug)
+
+		if err == nil {
+			before = existing
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return modAction{}, err
+		}
+
+		board, err = qtx.SetBoard(r.Context(), sqlc.SetBoardParams{
+			Slug:        slug,
+			Title:       strings.TrimSpace(req.Title),
+			Description: strings.TrimSpace(req.Description),
+			Settings:    settings,
+		})
+
+		return modAction{
+			Action:     modActionSetBoard,
+			TargetType: targetTypeBoard,
+			TargetId:   slug,
+			Reason:     req.Reason,
+			Before:     before,
+			After:      board,
+		}, err
+	})
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	marshResp, err := json.Marshal(board)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, response: %s", 200, marshResp))
+}
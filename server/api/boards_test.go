@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Every target type written by the mod routes has to pass the mod_action CHECK
+func TestModActionTargetTypes(t *testing.T) {
+	schema, err := os.ReadFile("../schema.sql")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	check := regexp.MustCompile(`target_type text NOT NULL CHECK \(target_type IN \(([^)]*)\)\)`).FindSubmatch(schema)
+
+	if check == nil {
+		t.Fatal("mod_action.target_type CHECK not found in schema.sql")
+	}
+
+	for _, targetType := range []string{targetTypePost, targetTypeComment, targetTypeAuthor, targetTypeBan, targetTypeBoard} {
+		if !strings.Contains(string(check[1]), "'"+targetType+"'") {
+			t.Errorf("target type '%s' is not allowed by the CHECK", targetType)
+		}
+	}
+}
+
+// Needs TEST_POSTGRES_URL pointing at a database with schema.sql loaded
+func TestSetBoard(t *testing.T) {
+	url := os.Getenv("TEST_POSTGRES_URL")
+
+	if url == "" {
+		t.Skip("TEST_POSTGRES_URL is not set")
+	}
+
+	t.Setenv("POSTGRES_URL", url)
+	db.ConnectDB()
+
+	ctx := context.Background()
+
+	admin, err := db.Query.CreateAuthor(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slug := fmt.Sprintf("test_%d", time.Now().UnixNano())
+
+	setBoard := func(title string) *httptest.ResponseRecorder {
+		body := strings.NewReader(fmt.Sprintf(`{"title": %q, "description": "", "settings": {}, "reason": "test"}`, title))
+		r := httptest.NewRequest(http.MethodPut, "/mod/boards/"+slug, body)
+
+		route := chi.NewRouteContext()
+		route.URLParams.Add("slug", slug)
+
+		reqCtx := context.WithValue(r.Context(), chi.RouteCtxKey, route)
+		reqCtx = context.WithValue(reqCtx, "requestId", "test")
+		reqCtx = context.WithValue(reqCtx, "author", admin.ID)
+		reqCtx = context.WithValue(reqCtx, "role", RoleAdmin)
+
+		w := httptest.NewRecorder()
+		SetBoard(w, r.WithContext(reqCtx))
+
+		return w
+	}
+
+	// Created, then updated
+	for _, title := range []string{"Test", "Renamed"} {
+		if w := setBoard(title); w.Code != http.StatusOK {
+			t.Fatalf("SetBoard(%q) = %d: %s", title, w.Code, w.Body)
+		}
+	}
+
+	board, err := db.Query.GetBoard(ctx, slug)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if board.Title != "Renamed" {
+		t.Errorf("title = %q, want %q", board.Title, "Renamed")
+	}
+
+	actions, err := db.Query.GetModActions(ctx, sqlc.GetModActionsParams{
+		Limit:      10,
+		TargetType: pgtype.Text{String: targetTypeBoard, Valid: true},
+		TargetID:   pgtype.Text{String: slug, Valid: true},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(actions) != 2 {
+		t.Errorf("%d mod actions logged, want 2", len(actions))
+	}
+}
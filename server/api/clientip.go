@@ -0,0 +1,154 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Comma separated CIDRs or addresses of proxies allowed to report the client ip.
+// Headers are ignored when the request does not come from one of them.
+var trustedProxies = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+
+// Only the header the trusted proxies set is read, the others are passed through
+// from the client as is. One of 'X-Forwarded-For' (default), 'Forwarded' or 'X-Real-Ip'.
+var clientIpHeader = os.Getenv("CLIENT_IP_HEADER")
+
+func parseTrustedProxies(str string) []netip.Prefix {
+	var prefixes []netip.Prefix
+
+	for _, part := range strings.Split(str, ",") {
+		part = strings.TrimSpace(part)
+
+		if part == "" {
+			continue
+		}
+
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+
+			if err != nil {
+				log.Println("Invalid address in TRUSTED_PROXIES: " + part)
+				continue
+			}
+
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(part)
+
+		if err != nil {
+			log.Println("Invalid CIDR in TRUSTED_PROXIES: " + part)
+			continue
+		}
+
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes
+}
+
+func isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Accepts '1.2.3.4', '1.2.3.4:80', '2001:db8::1', '[2001:db8::1]:80' and quoted forms of them
+func parseHop(str string) (netip.Addr, bool) {
+	str = strings.Trim(strings.TrimSpace(str), `"`)
+
+	if addrPort, err := netip.ParseAddrPort(str); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+
+	addr, err := netip.ParseAddr(strings.Trim(str, "[]"))
+
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
+
+// 'for' parameters of RFC 7239 Forwarded headers, from the farthest hop to the nearest
+func forwardedFor(header http.Header) []string {
+	var hops []string
+
+	for _, value := range header.Values("Forwarded") {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+
+				if ok && strings.EqualFold(key, "for") {
+					hops = append(hops, val)
+				}
+			}
+		}
+	}
+
+	return hops
+}
+
+func xForwardedFor(header http.Header) []string {
+	var hops []string
+
+	for _, value := range header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	return hops
+}
+
+// Walks the proxy chain from the nearest hop and returns the first address that
+// is not a trusted proxy, everything to the left of it could be forged by the client
+func resolveClientIp(r *http.Request) netip.Addr {
+	remote, ok := parseHop(r.RemoteAddr)
+
+	if !ok || !isTrustedProxy(remote) {
+		return remote
+	}
+
+	var hops []string
+
+	switch strings.ToLower(clientIpHeader) {
+	case "forwarded":
+		hops = forwardedFor(r.Header)
+	case "x-real-ip":
+		if realIp, ok := parseHop(r.Header.Get("X-Real-Ip")); ok {
+			return realIp
+		}
+	default:
+		hops = xForwardedFor(r.Header)
+	}
+
+	if len(hops) == 0 {
+		return remote
+	}
+
+	client := remote
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+
+		// 'unknown' or obfuscated identifiers, nothing beyond can be checked
+		if !ok {
+			break
+		}
+
+		client = hop
+
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+
+	return client
+}
@@ -0,0 +1,524 @@
+package api
+
+import (
+	"container/heap"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/netip"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// Device auth is a challenge/response flow, the client keeps a key pair and the
+// author is bound to the public key instead of the ip:
+//  1. POST /auth/device/challenge with the public key, server answers with a challenge
+//  2. Client signs the challenge string with its private key
+//  3. POST /auth/device with the public key, challenge and signature to get tokens
+//
+// Public key is base64 encoded SPKI (WebCrypto exportKey("spki")), ECDSA P-256 and
+// Ed25519 are supported. ECDSA signatures are over SHA-256 and can be either raw
+// r || s (what WebCrypto produces) or ASN.1.
+const deviceChallengeTTL = 2 * time.Minute
+const deviceChallengeType = "device-challenge"
+
+// Nonces of challenges that were already answered, kept until the challenge expires
+type nonceStore struct {
+	mu       sync.Mutex
+	nonces   map[string]time.Time
+	expiries nonceQueue // Same nonces, soonest expiry first
+}
+
+type nonceExpiry struct {
+	nonce     string
+	expiresAt time.Time
+}
+
+// Min-heap for container/heap
+type nonceQueue []nonceExpiry
+
+func (q nonceQueue) Len() int           { return len(q) }
+func (q nonceQueue) Less(i, j int) bool { return q[i].expiresAt.Before(q[j].expiresAt) }
+func (q nonceQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *nonceQueue) Push(x any)        { *q = append(*q, x.(nonceExpiry)) }
+
+func (q *nonceQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+var usedDeviceNonces = nonceStore{nonces: map[string]time.Time{}}
+
+// Returns false if nonce was already used
+func (s *nonceStore) use(nonce string, expiresAt time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	// Only the expired ones are looked at
+	for len(s.expiries) > 0 && s.expiries[0].expiresAt.Before(now) {
+		delete(s.nonces, heap.Pop(&s.expiries).(nonceExpiry).nonce)
+	}
+
+	if _, ok := s.nonces[nonce]; ok {
+		return false
+	}
+
+	s.nonces[nonce] = expiresAt
+	heap.Push(&s.expiries, nonceExpiry{nonce: nonce, expiresAt: expiresAt})
+
+	return true
+}
+
+func decodeBase64(str string) ([]byte, error) {
+	str = strings.TrimRight(str, "=")
+
+	if strings.ContainsAny(str, "-_") {
+		return base64.RawURLEncoding.DecodeString(str)
+	}
+
+	return base64.RawStdEncoding.DecodeString(str)
+}
+
+// Parses the key and returns it with its canonical DER form, so the same key
+// always maps to the same author no matter how it was encoded by the client
+func parseDevicePublicKey(str string) (any, []byte, error) {
+	der, err := decodeBase64(str)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, nil, errors.New("Only P-256 ECDSA keys are supported")
+		}
+	case ed25519.PublicKey:
+	default:
+		return nil, nil, fmt.Errorf("Unsupported key type: %T", key)
+	}
+
+	canonical, err := x509.MarshalPKIXPublicKey(key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return key, canonical, nil
+}
+
+func verifyDeviceSignature(key any, message []byte, signature []byte) bool {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(message)
+
+		if len(signature) == 64 {
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+
+			return ecdsa.Verify(k, hash[:], r, s)
+		}
+
+		return ecdsa.VerifyASN1(k, hash[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, message, signature)
+	}
+
+	return false
+}
+
+func publicKeyHash(der []byte) string {
+	sum := sha256.Sum256(der)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func DeviceChallenge(w http.ResponseWriter, r *http.Request) {
+	type DeviceChallengeReq struct {
+		PublicKey string `json:"publicKey"`
+	}
+	type DeviceChallengeResp struct {
+		Challenge string    `json:"challenge"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched DeviceChallenge route"))
+
+	var req DeviceChallengeReq
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	_, der, err := parseDevicePublicKey(req.PublicKey)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'publicKey' is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	nonce, err := uuid.NewV4()
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	expiresAt := time.Now().Add(deviceChallengeTTL)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"exp":   expiresAt.Unix(),
+		"nonce": nonce,
+		"pub":   publicKeyHash(der),
+	})
+
+	token.Header["kid"] = jwtKeys.signingKid
+	token.Header["typ"] = deviceChallengeType
+
+	challenge, err := token.SignedString(jwtKeys.signingKey)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	resp := DeviceChallengeResp{
+		Challenge: challenge,
+		ExpiresAt: expiresAt,
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, response: %s", 200, marshResp))
+}
+
+// Returns the author bound to the key, registering the key with a new author on first use
+func deviceAuthor(ctx context.Context, der []byte, ip *netip.Addr) (sqlc.Author, error) {
+	deviceKey, err := db.Query.GetDeviceKey(ctx, der)
+
+	if err == nil {
+		err = db.Query.TouchDeviceKey(ctx, sqlc.TouchDeviceKeyParams{
+			ID:     deviceKey.ID,
+			LastIp: ip,
+		})
+
+		if err != nil {
+			return sqlc.Author{}, err
+		}
+
+		return db.Query.GetAuthor(ctx, deviceKey.Author)
+	}
+
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return sqlc.Author{}, err
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+
+	if err != nil {
+		return sqlc.Author{}, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	qtx := db.Query.WithTx(tx)
+
+	author, err := qtx.CreateAuthor(ctx)
+
+	if err != nil {
+		return sqlc.Author{}, err
+	}
+
+	_, err = qtx.CreateDeviceKey(ctx, sqlc.CreateDeviceKeyParams{
+		Author:    author.ID,
+		PublicKey: der,
+		LastIp:    ip,
+	})
+
+	// Concurrent first login with the same key registered it first, the author created here is rolled back
+	if isPgError(err, "23505") {
+		tx.Rollback(ctx)
+
+		deviceKey, err = db.Query.GetDeviceKey(ctx, der)
+
+		if err != nil {
+			return sqlc.Author{}, err
+		}
+
+		return db.Query.GetAuthor(ctx, deviceKey.Author)
+	}
+
+	if err != nil {
+		return sqlc.Author{}, err
+	}
+
+	return author, tx.Commit(ctx)
+}
+
+func DeviceAuth(w http.ResponseWriter, r *http.Request) {
+	type DeviceAuthReq struct {
+		PublicKey string `json:"publicKey"`
+		Challenge string `json:"challenge"`
+		Signature string `json:"signature"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched DeviceAuth route"))
+
+	var req DeviceAuthReq
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	key, der, err := parseDevicePublicKey(req.PublicKey)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'publicKey' is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	challenge, err := jwt.Parse(req.Challenge, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["typ"] != deviceChallengeType {
+			return nil, errors.New("Token is not a device challenge")
+		}
+
+		kid, ok := token.Header["kid"].(string)
+
+		if !ok {
+			return nil, errors.New("Token has no 'kid' header")
+		}
+
+		return jwtKeys.lookup(kid)
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}))
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'challenge' is invalid or expired"),
+			cause:     err,
+			Code:      401,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	claims := challenge.Claims.(jwt.MapClaims)
+	nonce, _ := claims["nonce"].(string)
+	expiresAt, _ := claims.GetExpirationTime()
+
+	if claims["pub"] != publicKeyHash(der) || nonce == "" || expiresAt == nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'challenge' was issued for another key"),
+			cause:     errors.New("Unauthorized"),
+			Code:      401,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	signature, err := decodeBase64(req.Signature)
+
+	if err != nil || !verifyDeviceSignature(key, []byte(req.Challenge), signature) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'signature' is invalid"),
+			cause:     errors.New("Unauthorized"),
+			Code:      401,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if !usedDeviceNonces.use(nonce, expiresAt.Time) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'challenge' was already used"),
+			cause:     errors.New("Unauthorized"),
+			Code:      401,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	// Ip is only kept as metadata here, it does not identify the author
+	var ip *netip.Addr
+
+	if clientIp := r.Context().Value("clientIp").(netip.Addr); clientIp.IsValid() {
+		ip = &clientIp
+	}
+
+	// Banned ips get no author, nil uuid matches no author ban
+	ban, err := activeBan(r.Context(), uuid.Nil, r.Context().Value("clientIp").(netip.Addr))
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if ban != nil {
+		failBanned(w, requestId, ban)
+		return
+	}
+
+	author, err := deviceAuthor(r.Context(), der, ip)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	ban, err = activeBan(r.Context(), author.ID, r.Context().Value("clientIp").(netip.Addr))
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if ban != nil {
+		failBanned(w, requestId, ban)
+		return
+	}
+
+	family, err := uuid.NewV4()
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	resp, err := issueTokens(r.Context(), db.Query, author, family)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, author: %s", 200, resp.Uuid))
+}
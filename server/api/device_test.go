@@ -0,0 +1,33 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNonceStoreUse(t *testing.T) {
+	store := nonceStore{nonces: map[string]time.Time{}}
+	now := time.Now()
+
+	if !store.use("a", now.Add(time.Minute)) {
+		t.Fatal("first use of a nonce was refused")
+	}
+
+	if store.use("a", now.Add(time.Minute)) {
+		t.Fatal("second use of a nonce was allowed")
+	}
+
+	store.use("expired", now.Add(-time.Second))
+	store.use("b", now.Add(2*time.Minute))
+
+	// Pruned on the next use
+	store.use("c", now.Add(time.Minute))
+
+	if _, ok := store.nonces["expired"]; ok {
+		t.Error("expired nonce was kept")
+	}
+
+	if len(store.nonces) != 3 || store.expiries.Len() != 3 {
+		t.Errorf("%d nonces and %d expiries kept, want 3", len(store.nonces), store.expiries.Len())
+	}
+}
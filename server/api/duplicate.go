@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// New content is fingerprinted with a simhash of its character shingles, similar texts
+// get fingerprints that differ in few bits. Content whose fingerprint is at most
+// DUPLICATE_THRESHOLD bits (of 64) away from a post or comment of the last
+// DUPLICATE_WINDOW is a near-duplicate and DUPLICATE_ACTION ('reject' or 'hold')
+// is applied. Negative threshold turns the check off.
+var duplicateThreshold = envInt("DUPLICATE_THRESHOLD", 10)
+var duplicateWindow = envDuration("DUPLICATE_WINDOW", 24*time.Hour)
+var duplicateAction = os.Getenv("DUPLICATE_ACTION")
+
+// Short texts collide too often to tell spam from "+1"
+var duplicateMinWords = envInt("DUPLICATE_MIN_WORDS", 5)
+
+const shingleSize = 4 // Runes
+
+var errNearDuplicate = errors.New("Content is too similar to a recent post or comment")
+
+// Returns false when content is too short to be fingerprinted
+func simhash(content string) (int64, bool) {
+	words := strings.FieldsFunc(strings.ToLower(content), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	if len(words) < max(duplicateMinWords, 1) {
+		return 0, false
+	}
+
+	// Case, punctuation and spacing do not matter
+	text := []rune(strings.Join(words, " "))
+	size := min(shingleSize, len(text))
+
+	var weights [64]int
+
+	for i := 0; i+size <= len(text); i++ {
+		hash := fnv.New64a()
+		hash.Write([]byte(string(text[i : i+size])))
+		sum := hash.Sum64()
+
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+
+	for bit, weight := range weights {
+		if weight > 0 {
+			fingerprint |= 1 << bit
+		}
+	}
+
+	return int64(fingerprint), true
+}
+
+// Returns the fingerprint to store with the content, near-duplicates are either
+// held or rejected with errNearDuplicate. Edited content passes its own id, so
+// it is not compared to its previous version, new content passes 0.
+func checkDuplicate(ctx context.Context, requestId string, result *filterResult, postId int32, commentId int32) (pgtype.Int8, error) {
+	fingerprint, ok := simhash(result.Content)
+
+	if !ok {
+		return pgtype.Int8{}, nil
+	}
+
+	stored := pgtype.Int8{Int64: fingerprint, Valid: true}
+
+	if duplicateThreshold < 0 {
+		return stored, nil
+	}
+
+	duplicate, err := db.Query.HasNearDuplicate(ctx, sqlc.HasNearDuplicateParams{
+		Since:          pgtype.Timestamptz{Time: time.Now().Add(-duplicateWindow), Valid: true},
+		ExcludePost:    postId,
+		Fingerprint:    fingerprint,
+		MaxDistance:    int32(duplicateThreshold),
+		ExcludeComment: commentId,
+	})
+
+	if err != nil || !duplicate {
+		return stored, err
+	}
+
+	if duplicateAction == filterActionHold {
+		requestLog(requestId, fmt.Sprintf("Content is a near-duplicate, action: %s", filterActionHold))
+
+		if result.Visibility == visibilityVisible {
+			result.Visibility = visibilityHeld
+		}
+
+		return stored, nil
+	}
+
+	requestLog(requestId, fmt.Sprintf("Content is a near-duplicate, action: %s", filterActionReject))
+
+	return stored, errNearDuplicate
+}
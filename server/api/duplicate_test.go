@@ -0,0 +1,52 @@
+package api
+
+import (
+	"math/bits"
+	"testing"
+)
+
+func TestSimhash(t *testing.T) {
+	const base = "Selling cheap watches today, visit my shop for great deals"
+
+	tests := []struct {
+		name        string
+		other       string
+		maxDistance int // Inclusive, -1 when other is too short to fingerprint
+		minDistance int
+	}{
+		{"same", base, 0, 0},
+		{"case, punctuation and spacing", "selling CHEAP watches today!!! visit my   shop, for great deals", 0, 0},
+		{"one word changed", "Selling cheap watches today, visit my store for great deals", 10, 1},
+		{"unrelated", "The weather in the mountains was lovely during our hike last week", 64, 11},
+		{"too short", "cheap watches", -1, 0},
+	}
+
+	fingerprint, ok := simhash(base)
+
+	if !ok {
+		t.Fatal("base text is too short")
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			other, ok := simhash(test.other)
+
+			if test.maxDistance < 0 {
+				if ok {
+					t.Errorf("simhash(%q) fingerprinted a short text", test.other)
+				}
+				return
+			}
+
+			if !ok {
+				t.Fatalf("simhash(%q) is too short", test.other)
+			}
+
+			distance := bits.OnesCount64(uint64(fingerprint ^ other))
+
+			if distance < test.minDistance || distance > test.maxDistance {
+				t.Errorf("distance to %q = %d, want %d to %d", test.other, distance, test.minDistance, test.maxDistance)
+			}
+		})
+	}
+}
@@ -0,0 +1,645 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"snakesss/db"
+	"snakesss/markup"
+	"snakesss/sqlc"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Authors can edit their posts and comments for this long after creating them, 0 turns editing off
+var editWindow = envDuration("EDIT_WINDOW", 15*time.Minute)
+
+// Runs fn in one transaction, so either all of its writes are done or none
+func inTx(ctx context.Context, fn func(qtx *sqlc.Queries) error) error {
+	tx, err := db.Pool.Begin(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback(ctx)
+
+	err = fn(db.Query.WithTx(tx))
+
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// Checks the new content the same way as on create, fails the request when it is not accepted
+func checkEdit(w http.ResponseWriter, r *http.Request, content string, wasEmpty bool, maxSymbols int, postId int32, commentId int32) (filterResult, pgtype.Int8, bool) {
+	requestId := r.Context().Value("requestId").(string)
+
+	// Posts with only an image or a poll have no content to keep
+	if len(content) == 0 && !wasEmpty {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'content' is empty"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return filterResult{}, pgtype.Int8{}, false
+	}
+
+	if len(content) > maxSymbols {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New(fmt.Sprintf("'content' max length is %d", maxSymbols)),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return filterResult{}, pgtype.Int8{}, false
+	}
+
+	filtered, err := filter.apply(requestId, strings.TrimSpace(content))
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     err,
+			cause:     errors.New("Rejected by content filter"),
+			Code:      422,
+		}
+		fail(w, errReq)
+		return filtered, pgtype.Int8{}, false
+	}
+
+	// A replace rule can leave nothing of the content
+	if filtered.Content == "" && !wasEmpty {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'content' is empty"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return filtered, pgtype.Int8{}, false
+	}
+
+	fingerprint, err := checkDuplicate(r.Context(), requestId, &filtered, postId, commentId)
+
+	if errors.Is(err, errNearDuplicate) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     err,
+			cause:     errors.New("Near-duplicate content"),
+			Code:      409,
+		}
+		fail(w, errReq)
+		return filtered, fingerprint, false
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return filtered, fingerprint, false
+	}
+
+	return filtered, fingerprint, true
+}
+
+// Fails the request when the window to edit content created at createdAt has passed
+func checkEditWindow(w http.ResponseWriter, requestId string, createdAt pgtype.Timestamptz) bool {
+	if time.Since(createdAt.Time) < editWindow {
+		return true
+	}
+
+	errReq := RequestError{
+		RequestId: requestId,
+		error:     fmt.Errorf("Content can only be edited within %s after it is created", editWindow),
+		cause:     errors.New("Forbidden"),
+		Code:      403,
+	}
+	fail(w, errReq)
+	return false
+}
+
+func EditPost(w http.ResponseWriter, r *http.Request) {
+	type EditPostReq struct {
+		Content string `json:"content"`
+	}
+	type EditPostResp struct {
+		ID          int32              `json:"id"`
+		Content     string             `json:"content"`
+		ContentHtml string             `json:"contentHtml"`
+		EditedAt    pgtype.Timestamptz `json:"editedAt"`
+		IsHeld      bool               `json:"isHeld"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched EditPost route"))
+
+	postIdStr := chi.URLParam(r, "postId")
+
+	postId, err := strconv.Atoi(postIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'postId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	var req EditPostReq
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	author := r.Context().Value("author").(uuid.UUID)
+
+	post, err := db.Query.GetPostById(r.Context(), int32(postId))
+
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && post.DeletedAt.Valid) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Post not found"),
+			cause:     errors.New("Not found"),
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if post.Author != author {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Only the author can edit the post"),
+			cause:     errors.New("Forbidden"),
+			Code:      403,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if post.ArchivedAt.Valid {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Thread is archived, it can't be edited"),
+			cause:     errors.New("Forbidden"),
+			Code:      403,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if !checkEditWindow(w, requestId, post.CreatedAt) {
+		return
+	}
+
+	filtered, fingerprint, ok := checkEdit(w, r, req.Content, post.Content == "", maxSymbolsForPost, post.ID, 0)
+
+	if !ok {
+		return
+	}
+
+	var edited sqlc.Post
+
+	err = inTx(r.Context(), func(qtx *sqlc.Queries) error {
+		_, err := qtx.CreatePostRevision(r.Context(), post.ID)
+
+		if err != nil {
+			return err
+		}
+
+		edited, err = qtx.EditPost(r.Context(), sqlc.EditPostParams{
+			Content:       filtered.Content,
+			ContentHtml:   markup.Render(filtered.Content, nil),
+			Fingerprint:   fingerprint,
+			Visibility:    filtered.Visibility,
+			ID:            post.ID,
+			Author:        author,
+			EditableSince: pgtype.Timestamptz{Time: time.Now().Add(-editWindow), Valid: true},
+		})
+
+		return err
+	})
+
+	// Post was deleted, archived or ran out of the window since it was checked
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Post can no longer be edited"),
+			cause:     err,
+			Code:      409,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	resp := EditPostResp{
+		ID:          edited.ID,
+		Content:     edited.Content,
+		ContentHtml: edited.ContentHtml,
+		EditedAt:    edited.EditedAt,
+		IsHeld:      edited.Visibility == visibilityHeld,
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, response: %s", 200, marshResp))
+}
+
+func EditComment(w http.ResponseWriter, r *http.Request) {
+	type EditCommentReq struct {
+		Content string `json:"content"`
+	}
+	// Same as the EditPostResp, with the references of the new content
+	type EditCommentResp struct {
+		ID          int32              `json:"id"`
+		Content     string             `json:"content"`
+		ContentHtml string             `json:"contentHtml"`
+		Quotes      []commentRef       `json:"quotes"`
+		EditedAt    pgtype.Timestamptz `json:"editedAt"`
+		IsHeld      bool               `json:"isHeld"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched EditComment route"))
+
+	commentIdStr := chi.URLParam(r, "commentId")
+
+	commentId, err := strconv.Atoi(commentIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'commentId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	var req EditCommentReq
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	author := r.Context().Value("author").(uuid.UUID)
+
+	comment, err := db.Query.GetCommentById(r.Context(), int32(commentId))
+
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && comment.DeletedAt.Valid) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Comment not found"),
+			cause:     errors.New("Not found"),
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if comment.Author != author {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Only the author can edit the comment"),
+			cause:     errors.New("Forbidden"),
+			Code:      403,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	thread, err := db.Query.GetPostById(r.Context(), comment.Post)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if thread.LockedAt.Valid || thread.ArchivedAt.Valid {
+		reason := "Thread is locked, its comments can't be edited"
+
+		if thread.ArchivedAt.Valid {
+			reason = "Thread is archived, its comments can't be edited"
+		}
+
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New(reason),
+			cause:     errors.New("Forbidden"),
+			Code:      403,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if !checkEditWindow(w, requestId, comment.CreatedAt) {
+		return
+	}
+
+	filtered, fingerprint, ok := checkEdit(w, r, req.Content, comment.Content == "", maxSymbolsForComment, 0, comment.ID)
+
+	if !ok {
+		return
+	}
+
+	refPosts, quotes, ok := resolveRefs(w, r, filtered.Content, comment.ID)
+
+	if !ok {
+		return
+	}
+
+	var edited sqlc.Comment
+
+	err = inTx(r.Context(), func(qtx *sqlc.Queries) error {
+		_, err := qtx.CreateCommentRevision(r.Context(), comment.ID)
+
+		if err != nil {
+			return err
+		}
+
+		edited, err = qtx.EditComment(r.Context(), sqlc.EditCommentParams{
+			Content:       filtered.Content,
+			ContentHtml:   markup.Render(filtered.Content, refPosts),
+			Fingerprint:   fingerprint,
+			Visibility:    filtered.Visibility,
+			ID:            comment.ID,
+			Author:        author,
+			EditableSince: pgtype.Timestamptz{Time: time.Now().Add(-editWindow), Valid: true},
+		})
+
+		if err != nil {
+			return err
+		}
+
+		return saveRefs(r.Context(), qtx, comment.ID, quotes)
+	})
+
+	// Comment or its thread changed since they were checked
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Comment can no longer be edited"),
+			cause:     err,
+			Code:      409,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	resp := EditCommentResp{
+		ID:          edited.ID,
+		Content:     edited.Content,
+		ContentHtml: edited.ContentHtml,
+		Quotes:      quotes,
+		EditedAt:    edited.EditedAt,
+		IsHeld:      edited.Visibility == visibilityHeld,
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, response: %s", 200, marshResp))
+}
+
+// Previous versions, newest first, of a post the caller can see
+func GetPostRevisions(w http.ResponseWriter, r *http.Request) {
+	type GetPostRevisionsResp struct {
+		Revisions []sqlc.PostRevision `json:"revisions"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched GetPostRevisions route"))
+
+	postIdStr := chi.URLParam(r, "postId")
+
+	postId, err := strconv.Atoi(postIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'postId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	revisions, err := db.Query.GetPostRevisions(r.Context(), sqlc.GetPostRevisionsParams{
+		Post:   int32(postId),
+		Author: r.Context().Value("author").(uuid.UUID),
+	})
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	resp := GetPostRevisionsResp{
+		Revisions: revisions,
+	}
+
+	if resp.Revisions == nil {
+		resp.Revisions = make([]sqlc.PostRevision, 0)
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", 200))
+}
+
+// Same as GetPostRevisions
+func GetCommentRevisions(w http.ResponseWriter, r *http.Request) {
+	type GetCommentRevisionsResp struct {
+		Revisions []sqlc.CommentRevision `json:"revisions"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched GetCommentRevisions route"))
+
+	commentIdStr := chi.URLParam(r, "commentId")
+
+	commentId, err := strconv.Atoi(commentIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'commentId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	revisions, err := db.Query.GetCommentRevisions(r.Context(), sqlc.GetCommentRevisionsParams{
+		Comment: int32(commentId),
+		Author:  r.Context().Value("author").(uuid.UUID),
+	})
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	resp := GetCommentRevisionsResp{
+		Revisions: revisions,
+	}
+
+	if resp.Revisions == nil {
+		resp.Revisions = make([]sqlc.CommentRevision, 0)
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", 200))
+}
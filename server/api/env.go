@@ -0,0 +1,48 @@
+package api
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+func envDuration(name string, def time.Duration) time.Duration {
+	str := os.Getenv(name)
+
+	if str == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(str)
+
+	if err != nil {
+		log.Println("Invalid duration in " + name + ", using default")
+		return def
+	}
+
+	return d
+}
+
+func envInt(name string, def int) int {
+	str := os.Getenv(name)
+
+	if str == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(str)
+
+	if err != nil {
+		log.Println("Invalid number in " + name + ", using default")
+		return def
+	}
+
+	return i
+}
+
+func envBool(name string) bool {
+	b, _ := strconv.ParseBool(os.Getenv(name))
+
+	return b
+}
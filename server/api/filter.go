@@ -0,0 +1,396 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Content filter rules come from FILTER_RULES_FILE (json array of rules with the
+// same fields as the filter_rule table) or, when it is empty, from the filter_rule
+// table. Both are reloaded every FILTER_RELOAD_INTERVAL.
+//
+// Rule kinds:
+//   - regex: 'pattern' is a regular expression
+//   - words: 'pattern' is a comma or newline separated list of words, case insensitive
+//   - links: more than 'threshold' links
+//   - repeat: one character repeated more than 'threshold' times in a row
+//
+// Actions:
+//   - reject: request fails with 'message'
+//   - replace: matches are replaced with 'replacement', repeats are cut to 'threshold'
+//   - hold: content is only shown to its author until a moderator approves it
+//   - shadow: content is only shown to its author, who is not told about it
+//
+// Matches of dry run rules (or of all rules with FILTER_DRY_RUN) are only logged.
+var filterRulesFile = os.Getenv("FILTER_RULES_FILE")
+var filterDryRun = envBool("FILTER_DRY_RUN")
+var filterReloadInterval = envDuration("FILTER_RELOAD_INTERVAL", 30*time.Second)
+
+const (
+	visibilityVisible = "visible"
+	visibilityHeld    = "held"
+	visibilityShadow  = "shadow"
+)
+
+const (
+	filterKindRegex  = "regex"
+	filterKindWords  = "words"
+	filterKindLinks  = "links"
+	filterKindRepeat = "repeat"
+)
+
+const (
+	filterActionReject  = "reject"
+	filterActionReplace = "replace"
+	filterActionHold    = "hold"
+	filterActionShadow  = "shadow"
+)
+
+const defaultFilterMessage = "Content is not allowed"
+
+var linkRegexp = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+type filterRule struct {
+	sqlc.FilterRule
+	re *regexp.Regexp // Regex and words rules
+}
+
+type contentFilter struct {
+	mu      sync.RWMutex
+	rules   []filterRule
+	modTime time.Time // Of FILTER_RULES_FILE when it was last read
+}
+
+var filter = contentFilter{}
+
+type filterResult struct {
+	Content    string
+	Visibility string
+}
+
+func LoadFilter() {
+	if err := filter.reload(context.Background()); err != nil {
+		panic(err)
+	}
+
+	go func() {
+		for range time.Tick(filterReloadInterval) {
+			if err := filter.reload(context.Background()); err != nil {
+				log.Println(fmt.Sprintf("Filter reload failed, keeping previous rules: %s", err))
+			}
+		}
+	}()
+}
+
+func (f *contentFilter) reload(ctx context.Context) error {
+	var configs []sqlc.FilterRule
+
+	if filterRulesFile != "" {
+		info, err := os.Stat(filterRulesFile)
+
+		if err != nil {
+			return err
+		}
+
+		f.mu.RLock()
+		unchanged := info.ModTime().Equal(f.modTime)
+		f.mu.RUnlock()
+
+		if unchanged {
+			return nil
+		}
+
+		configs, err = readFilterRules(filterRulesFile)
+
+		if err != nil {
+			return err
+		}
+
+		// A broken file is not read again until it changes
+		defer func() {
+			f.mu.Lock()
+			f.modTime = info.ModTime()
+			f.mu.Unlock()
+		}()
+	} else {
+		var err error
+
+		configs, err = db.Query.GetFilterRules(ctx)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	rules := make([]filterRule, 0, len(configs))
+
+	for _, config := range configs {
+		rule, err := compileFilterRule(config)
+
+		if err != nil {
+			return fmt.Errorf("Filter rule %d: %w", config.ID, err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	f.mu.Lock()
+	f.rules = rules
+	f.mu.Unlock()
+
+	return nil
+}
+
+func readFilterRules(path string) ([]sqlc.FilterRule, error) {
+	data, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var raws []json.RawMessage
+
+	err = json.Unmarshal(data, &raws)
+
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make([]sqlc.FilterRule, 0, len(raws))
+
+	for i, raw := range raws {
+		// Rules in the file are enabled unless they say otherwise
+		config := sqlc.FilterRule{ID: int32(i + 1), Enabled: true}
+
+		err = json.Unmarshal(raw, &config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if config.Enabled {
+			configs = append(configs, config)
+		}
+	}
+
+	return configs, nil
+}
+
+func compileFilterRule(config sqlc.FilterRule) (filterRule, error) {
+	rule := filterRule{FilterRule: config}
+
+	switch config.Action {
+	case filterActionReject, filterActionReplace, filterActionHold, filterActionShadow:
+	default:
+		return rule, fmt.Errorf("Unknown action '%s'", config.Action)
+	}
+
+	switch config.Kind {
+	case filterKindRegex:
+		re, err := regexp.Compile(config.Pattern)
+
+		if err != nil {
+			return rule, err
+		}
+
+		rule.re = re
+	case filterKindWords:
+		var words []string
+
+		for _, word := range strings.FieldsFunc(config.Pattern, func(r rune) bool { return r == ',' || r == '\n' }) {
+			if word = strings.TrimSpace(word); word != "" {
+				words = append(words, regexp.QuoteMeta(word))
+			}
+		}
+
+		if len(words) == 0 {
+			return rule, errors.New("Word list is empty")
+		}
+
+		// \b only knows ascii letters, so word boundaries are matched explicitly
+		rule.re = regexp.MustCompile(`(?i)(^|[^\p{L}\p{N}_])(?:` + strings.Join(words, "|") + `)($|[^\p{L}\p{N}_])`)
+	case filterKindLinks:
+		if config.Threshold < 0 {
+			return rule, errors.New("Threshold is negative")
+		}
+	case filterKindRepeat:
+		if config.Threshold < 1 {
+			return rule, errors.New("Threshold must be at least 1")
+		}
+	default:
+		return rule, fmt.Errorf("Unknown kind '%s'", config.Kind)
+	}
+
+	return rule, nil
+}
+
+func (rule *filterRule) matches(content string) bool {
+	switch rule.Kind {
+	case filterKindRegex, filterKindWords:
+		return rule.re.MatchString(content)
+	case filterKindLinks:
+		return len(linkRegexp.FindAllStringIndex(content, -1)) > int(rule.Threshold)
+	case filterKindRepeat:
+		return longestRun(content) > int(rule.Threshold)
+	}
+
+	return false
+}
+
+func (rule *filterRule) replace(content string) string {
+	replacement := strings.ReplaceAll(rule.Replacement, "$", "$$")
+
+	switch rule.Kind {
+	case filterKindRegex:
+		return rule.re.ReplaceAllString(content, replacement)
+	case filterKindWords:
+		return rule.replaceWords(content)
+	case filterKindLinks:
+		return linkRegexp.ReplaceAllString(content, replacement)
+	case filterKindRepeat:
+		return cutRuns(content, int(rule.Threshold))
+	}
+
+	return content
+}
+
+// Boundary after a match can be the boundary before the next one, so every search
+// starts at it. Only the original content is searched, a replacement containing
+// one of the words is not replaced again.
+func (rule *filterRule) replaceWords(content string) string {
+	var builder strings.Builder
+	last, pos := 0, 0
+
+	for pos < len(content) {
+		loc := rule.re.FindStringSubmatchIndex(content[pos:])
+
+		if loc == nil {
+			break
+		}
+
+		// '^' is the start of the content, not of the rest that is searched
+		if pos > 0 && loc[2] == loc[3] {
+			if before, _ := utf8.DecodeLastRuneInString(content[:pos]); isWordRune(before) {
+				_, size := utf8.DecodeRuneInString(content[pos:])
+				pos += size
+				continue
+			}
+		}
+
+		builder.WriteString(content[last : pos+loc[3]])
+		builder.WriteString(rule.Replacement)
+		last = pos + loc[4]
+
+		// Empty boundary after the word is the end of the content
+		if loc[4] == loc[5] {
+			break
+		}
+
+		pos = last
+	}
+
+	builder.WriteString(content[last:])
+
+	return builder.String()
+}
+
+// Same as [\p{L}\p{N}_] in the words regexp
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_'
+}
+
+func longestRun(content string) int {
+	longest, run := 0, 0
+	var prev rune
+
+	for i, r := range content {
+		if i > 0 && r == prev {
+			run++
+		} else {
+			run = 1
+		}
+
+		prev = r
+		longest = max(longest, run)
+	}
+
+	return longest
+}
+
+func cutRuns(content string, limit int) string {
+	var builder strings.Builder
+	run := 0
+	var prev rune
+
+	for i, r := range content {
+		if i > 0 && r == prev {
+			run++
+		} else {
+			run = 1
+		}
+
+		prev = r
+
+		if run <= limit {
+			builder.WriteRune(r)
+		}
+	}
+
+	return builder.String()
+}
+
+// Runs content through the rules in order, returned error is the rejection message
+func (f *contentFilter) apply(requestId string, content string) (filterResult, error) {
+	f.mu.RLock()
+	rules := f.rules
+	f.mu.RUnlock()
+
+	result := filterResult{
+		Content:    content,
+		Visibility: visibilityVisible,
+	}
+
+	for _, rule := range rules {
+		if !rule.matches(result.Content) {
+			continue
+		}
+
+		if filterDryRun || rule.DryRun {
+			requestLog(requestId, fmt.Sprintf("Filter rule %d (%s) matched, dry run, action '%s' not applied", rule.ID, rule.Kind, rule.Action))
+			continue
+		}
+
+		requestLog(requestId, fmt.Sprintf("Filter rule %d (%s) matched, action: %s", rule.ID, rule.Kind, rule.Action))
+
+		switch rule.Action {
+		case filterActionReject:
+			if rule.Message == "" {
+				return result, errors.New(defaultFilterMessage)
+			}
+
+			return result, errors.New(rule.Message)
+		case filterActionReplace:
+			result.Content = rule.replace(result.Content)
+		case filterActionHold:
+			if result.Visibility != visibilityShadow {
+				result.Visibility = visibilityHeld
+			}
+		case filterActionShadow:
+			result.Visibility = visibilityShadow
+		}
+	}
+
+	return result, nil
+}
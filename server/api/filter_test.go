@@ -0,0 +1,64 @@
+package api
+
+import (
+	"snakesss/sqlc"
+	"strings"
+	"testing"
+)
+
+func TestFilterRuleReplace(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  sqlc.FilterRule
+		content string
+		want    string
+	}{
+		{"words", sqlc.FilterRule{Kind: filterKindWords, Pattern: "spam, scam", Replacement: "***"}, "Spam and SCAM.", "*** and ***."},
+		{"adjacent words", sqlc.FilterRule{Kind: filterKindWords, Pattern: "spam", Replacement: "***"}, "spam spam spam", "*** *** ***"},
+		{"words after punctuation", sqlc.FilterRule{Kind: filterKindWords, Pattern: "spam", Replacement: "***"}, "spam,spam.spam", "***,***.***"},
+		{"word starting with punctuation", sqlc.FilterRule{Kind: filterKindWords, Pattern: "+1", Replacement: "***"}, "+1 +1 a+1", "*** *** a+1"},
+		{"part of a word", sqlc.FilterRule{Kind: filterKindWords, Pattern: "spam", Replacement: "***"}, "spammer spamé", "spammer spamé"},
+		{"non-ascii word", sqlc.FilterRule{Kind: filterKindWords, Pattern: "слово", Replacement: "***"}, "одно слово, два", "одно ***, два"},
+		{"empty replacement", sqlc.FilterRule{Kind: filterKindWords, Pattern: "spam"}, "spam", ""},
+		{"replacement is literal", sqlc.FilterRule{Kind: filterKindWords, Pattern: "spam", Replacement: "$1${2}"}, "a spam b", "a $1${2} b"},
+		{"regex", sqlc.FilterRule{Kind: filterKindRegex, Pattern: `\d+`, Replacement: "#"}, "a1b22", "a#b#"},
+		{"regex replacement is literal", sqlc.FilterRule{Kind: filterKindRegex, Pattern: `b`, Replacement: "$0"}, "abc", "a$0c"},
+		{"links", sqlc.FilterRule{Kind: filterKindLinks, Replacement: "[link]"}, "go https://a.com or www.b.com now", "go [link] or [link] now"},
+		{"repeat", sqlc.FilterRule{Kind: filterKindRepeat, Threshold: 3}, "aaaaab!!!!!", "aaab!!!"},
+		{"repeat of runes", sqlc.FilterRule{Kind: filterKindRepeat, Threshold: 2}, "ёёёё", "ёё"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.config.Action = filterActionReplace
+
+			rule, err := compileFilterRule(test.config)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := rule.replace(test.content)
+
+			if got != test.want {
+				t.Errorf("replace(%q) = %q, want %q", test.content, got, test.want)
+			}
+		})
+	}
+}
+
+// Replacement that contains the word is not replaced again, so content does not grow with every pass
+func TestFilterRuleReplaceOnce(t *testing.T) {
+	rule, err := compileFilterRule(sqlc.FilterRule{Kind: filterKindWords, Pattern: "spam", Action: filterActionReplace, Replacement: "spam spam"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := strings.Repeat("spam ", 200)
+	want := strings.Repeat("spam spam ", 200)
+
+	if got := rule.replace(content); got != want {
+		t.Errorf("replace(%q) = %q, want %q", content, got, want)
+	}
+}
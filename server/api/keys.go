@@ -0,0 +1,232 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+)
+
+// Keys are read from JWT_KEYS_FILE (path to a PEM file) or JWT_KEYS (PEM itself).
+// The first private key is used for signing, every key in the file is accepted
+// for verification. To rotate, put the new private key on top and keep the old
+// one (private or public) below it until tokens signed by it are expired.
+// Kid is taken from the "kid" PEM header or, if absent, from the RFC 7638 thumbprint.
+type keySet struct {
+	signingKid string
+	signingKey *ecdsa.PrivateKey
+	keys       map[string]*ecdsa.PublicKey
+	order      []string
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+}
+
+var jwtKeys *keySet
+
+func LoadKeys() {
+	var data []byte
+	var err error
+
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		data, err = os.ReadFile(path)
+
+		if err != nil {
+			panic(err)
+		}
+	} else if env := os.Getenv("JWT_KEYS"); env != "" {
+		data = []byte(env)
+	}
+
+	if data == nil {
+		log.Println("JWT_KEYS_FILE and JWT_KEYS are empty, using ephemeral signing key, tokens will not survive restart")
+
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+		if err != nil {
+			panic(err)
+		}
+
+		ks := &keySet{keys: map[string]*ecdsa.PublicKey{}}
+
+		if err := ks.add("", key); err != nil {
+			panic(err)
+		}
+
+		jwtKeys = ks
+		return
+	}
+
+	ks, err := parseKeySet(data)
+
+	if err != nil {
+		panic(err)
+	}
+
+	jwtKeys = ks
+}
+
+func parseKeySet(data []byte) (*keySet, error) {
+	ks := &keySet{keys: map[string]*ecdsa.PublicKey{}}
+
+	for {
+		var block *pem.Block
+
+		block, data = pem.Decode(data)
+
+		if block == nil {
+			break
+		}
+
+		var key any
+		var err error
+
+		switch block.Type {
+		case "EC PARAMETERS":
+			// openssl ecparam -genkey emits it in front of the key
+			continue
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		default:
+			return nil, fmt.Errorf("Unsupported PEM block type: %s", block.Type)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := ks.add(block.Headers["kid"], key); err != nil {
+			return nil, err
+		}
+	}
+
+	if ks.signingKey == nil {
+		return nil, errors.New("No private key found for signing tokens")
+	}
+
+	return ks, nil
+}
+
+func (ks *keySet) add(kid string, key any) error {
+	var pub *ecdsa.PublicKey
+
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		pub = &k.PublicKey
+	case *ecdsa.PublicKey:
+		pub = k
+	default:
+		return fmt.Errorf("Unsupported key type: %T", key)
+	}
+
+	if pub.Curve != elliptic.P256() {
+		return errors.New("Only P-256 keys can be used with ES256")
+	}
+
+	if kid == "" {
+		kid = thumbprint(pub)
+	}
+
+	if _, ok := ks.keys[kid]; ok {
+		return fmt.Errorf("Duplicate key id: %s", kid)
+	}
+
+	ks.keys[kid] = pub
+	ks.order = append(ks.order, kid)
+
+	if priv, ok := key.(*ecdsa.PrivateKey); ok && ks.signingKey == nil {
+		ks.signingKey = priv
+		ks.signingKid = kid
+	}
+
+	return nil
+}
+
+func (ks *keySet) lookup(kid string) (*ecdsa.PublicKey, error) {
+	key, ok := ks.keys[kid]
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown key id: %s", kid)
+	}
+
+	return key, nil
+}
+
+func toJWK(kid string, pub *ecdsa.PublicKey) jwk {
+	ecdhKey, _ := pub.ECDH()
+	// Uncompressed point: 0x04 || X || Y
+	point := ecdhKey.Bytes()
+
+	return jwk{
+		Kty: "EC",
+		Crv: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(point[1:33]),
+		Y:   base64.RawURLEncoding.EncodeToString(point[33:]),
+		Kid: kid,
+		Use: "sig",
+		Alg: "ES256",
+	}
+}
+
+func thumbprint(pub *ecdsa.PublicKey) string {
+	k := toJWK("", pub)
+	// Members in lexicographic order, as RFC 7638 requires
+	canonical := fmt.Sprintf(`{"crv":"%s","kty":"%s","x":"%s","y":"%s"}`, k.Crv, k.Kty, k.X, k.Y)
+	sum := sha256.Sum256([]byte(canonical))
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func JWKS(w http.ResponseWriter, r *http.Request) {
+	type JWKSResp struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched JWKS route"))
+
+	resp := JWKSResp{
+		Keys: make([]jwk, 0, len(jwtKeys.order)),
+	}
+
+	for _, kid := range jwtKeys.order {
+		resp.Keys = append(resp.Keys, toJWK(kid, jwtKeys.keys[kid]))
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", 200))
+}
@@ -4,14 +4,26 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/netip"
 	"os"
+	"slices"
 	"time"
 
 	"github.com/gofrs/uuid"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Must go before LoggerMiddleware, see clientip.go
+func ClientIpMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), "clientIp", resolveClientIp(r))
+
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func LoggerMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		requestUUID, err := uuid.NewV4()
@@ -29,7 +41,7 @@ func LoggerMiddleware(h http.Handler) http.Handler {
 
 		w.Header().Add("X-Request-ID", requestUUID.String())
 
-		requestLog(requestUUID.String(), fmt.Sprintf("Incoming request, method: %s, path: %s, headers: %s, body: %s", r.Method, r.URL, r.Header, r.Body))
+		requestLog(requestUUID.String(), fmt.Sprintf("Incoming request, ip: %s, method: %s, path: %s, headers: %s, body: %s", r.Context().Value("clientIp"), r.Method, r.URL, r.Header, r.Body))
 
 		ctx := context.WithValue(r.Context(), "requestId", requestUUID.String())
 
@@ -39,7 +51,18 @@ func LoggerMiddleware(h http.Handler) http.Handler {
 
 func RequestSizeMiddleware(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Kept for routes with a different limit
+		ctx := context.WithValue(r.Context(), "body", r.Body)
+
 		r.Body = http.MaxBytesReader(w, r.Body, maxRequestSizeInBytes)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Replaces the limit of RequestSizeMiddleware for routes with uploads
+func UploadSizeMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Context().Value("body").(io.ReadCloser), uploadMaxSize)
 		h.ServeHTTP(w, r)
 	})
 }
@@ -50,7 +73,7 @@ func MainMiddleware(h http.Handler) http.Handler {
 		w.Header().Add("Access-Control-Allow-Origin", os.Getenv("HOST"))
 		w.Header().Set("Access-Control-Allow-Methods", "*")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
-		w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, X-Pow")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(200)
@@ -89,7 +112,13 @@ func AuthMiddleware(next http.Handler) http.Handler {
 				return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
 			}
 
-			return &jwtKey.PublicKey, nil
+			kid, ok := token.Header["kid"].(string)
+
+			if !ok {
+				return nil, errors.New("Token has no 'kid' header")
+			}
+
+			return jwtKeys.lookup(kid)
 		})
 
 		if err != nil {
@@ -116,12 +145,37 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		author, err := uuid.FromString(claims["uuid"].(string))
+		uuidStr, _ := claims["uuid"].(string)
+		jtiStr, _ := claims["jti"].(string)
+		familyStr, _ := claims["fam"].(string)
+
+		author, authorErr := uuid.FromString(uuidStr)
+		jti, jtiErr := uuid.FromString(jtiStr)
+		family, familyErr := uuid.FromString(familyStr)
+		issuedAt, iatErr := claims.GetIssuedAt()
+		expiresAt, expErr := claims.GetExpirationTime()
+
+		err = errors.Join(authorErr, jtiErr, familyErr, iatErr, expErr)
+
+		if err == nil && (issuedAt == nil || expiresAt == nil) {
+			err = errors.New("Token has no 'iat' or 'exp' claim")
+		}
 
 		if err != nil {
 			errReq := RequestError{
 				RequestId: requestId,
 				error:     errors.New("Invalid token"),
+				cause:     err,
+				Code:      401,
+			}
+			fail(w, errReq)
+			return
+		}
+
+		if revocations.isRevoked(jti, author, family, issuedAt.Time) {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("Token is revoked"),
 				cause:     errors.New("Unauthorized"),
 				Code:      401,
 			}
@@ -129,8 +183,105 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
+		// Banned authors can still read and log out, other writes are refused
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions && r.URL.Path != "/auth/logout" {
+			ban, err := activeBan(r.Context(), author, r.Context().Value("clientIp").(netip.Addr))
+
+			if err != nil {
+				errReq := RequestError{
+					RequestId: requestId,
+					error:     errors.New("Internal server error"),
+					cause:     err,
+					Code:      500,
+				}
+				fail(w, errReq)
+				return
+			}
+
+			if ban != nil {
+				failBanned(w, requestId, ban)
+				return
+			}
+		}
+
 		ctx := context.WithValue(r.Context(), "author", author)
+		ctx = context.WithValue(ctx, "jti", jti)
+		ctx = context.WithValue(ctx, "family", family)
+		ctx = context.WithValue(ctx, "tokenExp", expiresAt.Time)
+
+		role, ok := claims["role"].(string)
+
+		if !ok {
+			role = RoleUser
+		}
+
+		ctx = context.WithValue(ctx, "role", role)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// Must go after AuthMiddleware
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestId := r.Context().Value("requestId").(string)
+			role := r.Context().Value("role").(string)
+
+			if !slices.Contains(roles, role) {
+				errReq := RequestError{
+					RequestId: requestId,
+					error:     errors.New("Forbidden"),
+					cause:     fmt.Errorf("Role '%s' is not allowed, required one of %v", role, roles),
+					Code:      403,
+				}
+				fail(w, errReq)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Requires a solved proof of work challenge in 'X-Pow' header, see pow.go
+func PowMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Context().Value("requestId").(string)
+
+		powLoad.hit()
+
+		if currentPowDifficulty() == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header := r.Header.Get("X-Pow")
+
+		if header == "" {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("Proof of work required"),
+				cause:     errors.New("No 'X-Pow' header provided"),
+				Code:      428,
+			}
+			fail(w, errReq)
+			return
+		}
+
+		err := verifyPow(header)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("Invalid proof of work"),
+				cause:     err,
+				Code:      428,
+			}
+			fail(w, errReq)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
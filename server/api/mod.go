@@ -0,0 +1,1020 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+func ModDeletePost(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched ModDeletePost route"))
+
+	postIdStr := chi.URLParam(r, "postId")
+
+	postId, err := strconv.Atoi(postIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'postId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	err = auditPost(r.Context(), moderator, int32(postId), modActionDeletePost, modReason(r), func(qtx *sqlc.Queries) error {
+		deleted, err := qtx.DeletePost(r.Context(), sqlc.DeletePostParams{
+			ID:        int32(postId),
+			DeletedBy: pgtype.UUID{Bytes: moderator, Valid: true},
+		})
+
+		if err == nil && deleted == 0 {
+			return pgx.ErrNoRows
+		}
+
+		return err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Post not found or already deleted"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
+
+func ModDeleteComment(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched ModDeleteComment route"))
+
+	commentIdStr := chi.URLParam(r, "commentId")
+
+	commentId, err := strconv.Atoi(commentIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'commentId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	err = auditComment(r.Context(), moderator, int32(commentId), modActionDeleteComment, modReason(r), func(qtx *sqlc.Queries) error {
+		deleted, err := qtx.DeleteComment(r.Context(), sqlc.DeleteCommentParams{
+			ID:        int32(commentId),
+			DeletedBy: pgtype.UUID{Bytes: moderator, Valid: true},
+		})
+
+		if err == nil && deleted == 0 {
+			return pgx.ErrNoRows
+		}
+
+		return err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Comment not found or already deleted"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
+
+func SetAuthorRole(w http.ResponseWriter, r *http.Request) {
+	type SetAuthorRoleReq struct {
+		Role   string `json:"role"`
+		Reason string `json:"reason"`
+	}
+	type SetAuthorRoleResp struct {
+		ID   uuid.UUID `json:"id"`
+		Role string    `json:"role"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched SetAuthorRole route"))
+
+	authorIdStr := chi.URLParam(r, "authorId")
+
+	authorId, err := uuid.FromString(authorIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'authorId' is not a uuid"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	var req SetAuthorRoleReq
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if !slices.Contains(roles, req.Role) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New(fmt.Sprintf("'role' must be one of %v", roles)),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	author := r.Context().Value("author").(uuid.UUID)
+
+	if authorId == author && req.Role != RoleAdmin {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Admin can not demote themselves"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	var updated sqlc.Author
+	var revoked sqlc.RevokedAuthor
+
+	err = inModTx(r.Context(), author, func(qtx *sqlc.Queries) (modAction, error) {
+		before, err := qtx.GetAuthor(r.Context(), authorId)
+
+		if err != nil {
+			return modAction{}, err
+		}
+
+		updated, err = qtx.SetAuthorRole(r.Context(), sqlc.SetAuthorRoleParams{
+			ID:   authorId,
+			Role: req.Role,
+		})
+
+		if err != nil {
+			return modAction{}, err
+		}
+
+		// Role is a token claim, so old tokens are revoked and the refreshed ones get the new role
+		revoked, err = qtx.RevokeAuthorTokens(r.Context(), authorId)
+
+		return modAction{
+			Action:     modActionSetRole,
+			TargetType: targetTypeAuthor,
+			TargetId:   authorId.String(),
+			Reason:     req.Reason,
+			Before:     before,
+			After:      updated,
+		}, err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Author not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	revocations.addAuthor(authorId, revoked.RevokedAt.Time)
+
+	resp := SetAuthorRoleResp{
+		ID:   updated.ID,
+		Role: updated.Role,
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, response: %s", 200, marshResp))
+}
+
+func RestorePost(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched RestorePost route"))
+
+	postIdStr := chi.URLParam(r, "postId")
+
+	postId, err := strconv.Atoi(postIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'postId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	err = auditPost(r.Context(), moderator, int32(postId), modActionRestorePost, modReason(r), func(qtx *sqlc.Queries) error {
+		restored, err := qtx.RestorePost(r.Context(), int32(postId))
+
+		if err == nil && restored == 0 {
+			return pgx.ErrNoRows
+		}
+
+		return err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Deleted post not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
+
+func RestoreComment(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched RestoreComment route"))
+
+	commentIdStr := chi.URLParam(r, "commentId")
+
+	commentId, err := strconv.Atoi(commentIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'commentId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	err = auditComment(r.Context(), moderator, int32(commentId), modActionRestoreComment, modReason(r), func(qtx *sqlc.Queries) error {
+		restored, err := qtx.RestoreComment(r.Context(), int32(commentId))
+
+		if err == nil && restored == 0 {
+			return pgx.ErrNoRows
+		}
+
+		return err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Deleted comment not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
+
+const heldPerLoad = 50
+
+func GetHeld(w http.ResponseWriter, r *http.Request) {
+	type GetHeldResp struct {
+		NextOffset *int           `json:"nextOffset"`
+		Posts      []sqlc.Post    `json:"posts"`
+		Comments   []sqlc.Comment `json:"comments"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched GetHeld route"))
+
+	offsetStr := r.URL.Query().Get("offset")
+
+	var offset int32
+
+	if offsetStr != "" {
+		offset64, err := strconv.Atoi(offsetStr)
+
+		offset = int32(offset64)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("'offset' is not a number"),
+				cause:     errors.New("Bad request"),
+				Code:      400,
+			}
+			fail(w, errReq)
+			return
+		}
+	}
+
+	posts, err := db.Query.GetHeldPosts(r.Context(), sqlc.GetHeldPostsParams{
+		Limit:  heldPerLoad,
+		Offset: offset,
+	})
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	comments, err := db.Query.GetHeldComments(r.Context(), sqlc.GetHeldCommentsParams{
+		Limit:  heldPerLoad,
+		Offset: offset,
+	})
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	var nextOffset *int
+
+	if len(posts) >= heldPerLoad || len(comments) >= heldPerLoad {
+		temp := int(offset) + heldPerLoad
+		nextOffset = &temp
+	}
+
+	resp := GetHeldResp{
+		NextOffset: nextOffset,
+		Posts:      posts,
+		Comments:   comments,
+	}
+
+	if resp.Posts == nil {
+		resp.Posts = make([]sqlc.Post, 0)
+	}
+
+	if resp.Comments == nil {
+		resp.Comments = make([]sqlc.Comment, 0)
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", 200))
+}
+
+// Makes held or shadowed post visible to everyone
+func ApprovePost(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched ApprovePost route"))
+
+	postIdStr := chi.URLParam(r, "postId")
+
+	postId, err := strconv.Atoi(postIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'postId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	err = auditPost(r.Context(), moderator, int32(postId), modActionApprovePost, modReason(r), func(qtx *sqlc.Queries) error {
+		approved, err := qtx.ApprovePost(r.Context(), int32(postId))
+
+		if err == nil && approved == 0 {
+			return pgx.ErrNoRows
+		}
+
+		return err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Hidden post not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
+
+func ApproveComment(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched ApproveComment route"))
+
+	commentIdStr := chi.URLParam(r, "commentId")
+
+	commentId, err := strconv.Atoi(commentIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'commentId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	err = auditComment(r.Context(), moderator, int32(commentId), modActionApproveComment, modReason(r), func(qtx *sqlc.Queries) error {
+		approved, err := qtx.ApproveComment(r.Context(), int32(commentId))
+
+		if err == nil && approved == 0 {
+			return pgx.ErrNoRows
+		}
+
+		return err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Hidden comment not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
+
+// Shadowbanned author keeps posting as usual, but nobody else sees it
+func SetAuthorShadowban(w http.ResponseWriter, r *http.Request) {
+	type SetAuthorShadowbanReq struct {
+		Shadowbanned bool   `json:"shadowbanned"`
+		Reason       string `json:"reason"`
+	}
+	type SetAuthorShadowbanResp struct {
+		ID           uuid.UUID `json:"id"`
+		Shadowbanned bool      `json:"shadowbanned"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched SetAuthorShadowban route"))
+
+	authorIdStr := chi.URLParam(r, "authorId")
+
+	authorId, err := uuid.FromString(authorIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'authorId' is not a uuid"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	var req SetAuthorShadowbanReq
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	var updated sqlc.Author
+
+	err = inModTx(r.Context(), moderator, func(qtx *sqlc.Queries) (modAction, error) {
+		before, err := qtx.GetAuthor(r.Context(), authorId)
+
+		if err != nil {
+			return modAction{}, err
+		}
+
+		updated, err = qtx.SetAuthorShadowban(r.Context(), sqlc.SetAuthorShadowbanParams{
+			ID:           authorId,
+			Shadowbanned: req.Shadowbanned,
+		})
+
+		return modAction{
+			Action:     modActionShadowban,
+			TargetType: targetTypeAuthor,
+			TargetId:   authorId.String(),
+			Reason:     req.Reason,
+			Before:     before,
+			After:      updated,
+		}, err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Author not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	resp := SetAuthorShadowbanResp{
+		ID:           updated.ID,
+		Shadowbanned: updated.Shadowbanned,
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, response: %s", 200, marshResp))
+}
+
+func LockPost(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched LockPost route"))
+
+	postIdStr := chi.URLParam(r, "postId")
+
+	postId, err := strconv.Atoi(postIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'postId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	err = auditPost(r.Context(), moderator, int32(postId), modActionLockPost, modReason(r), func(qtx *sqlc.Queries) error {
+		locked, err := qtx.LockPost(r.Context(), sqlc.LockPostParams{
+			ID:       int32(postId),
+			LockedBy: pgtype.UUID{Bytes: moderator, Valid: true},
+		})
+
+		if err == nil && locked == 0 {
+			return pgx.ErrNoRows
+		}
+
+		return err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Unlocked post not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
+
+func UnlockPost(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched UnlockPost route"))
+
+	postIdStr := chi.URLParam(r, "postId")
+
+	postId, err := strconv.Atoi(postIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'postId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	err = auditPost(r.Context(), moderator, int32(postId), modActionUnlockPost, modReason(r), func(qtx *sqlc.Queries) error {
+		unlocked, err := qtx.UnlockPost(r.Context(), int32(postId))
+
+		if err == nil && unlocked == 0 {
+			return pgx.ErrNoRows
+		}
+
+		return err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Locked post not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
+
+func PinPost(w http.ResponseWriter, r *http.Request) {
+	type PinPostReq struct {
+		Order     int32      `json:"order"`     // Lower comes first
+		ExpiresAt *time.Time `json:"expiresAt"` // Pinned until unpinned when empty
+		Reason    string     `json:"reason"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched PinPost route"))
+
+	postIdStr := chi.URLParam(r, "postId")
+
+	postId, err := strconv.Atoi(postIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'postId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	var req PinPostReq
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'expiresAt' is in the past"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	params := sqlc.PinPostParams{
+		ID:       int32(postId),
+		PinnedBy: pgtype.UUID{Bytes: moderator, Valid: true},
+		PinOrder: req.Order,
+	}
+
+	if req.ExpiresAt != nil {
+		params.PinnedUntil = pgtype.Timestamptz{Time: *req.ExpiresAt, Valid: true}
+	}
+
+	err = auditPost(r.Context(), moderator, int32(postId), modActionPinPost, req.Reason, func(qtx *sqlc.Queries) error {
+		pinned, err := qtx.PinPost(r.Context(), params)
+
+		if err == nil && pinned == 0 {
+			return pgx.ErrNoRows
+		}
+
+		return err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Post not found or archived"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
+
+func UnpinPost(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched UnpinPost route"))
+
+	postIdStr := chi.URLParam(r, "postId")
+
+	postId, err := strconv.Atoi(postIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'postId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	err = auditPost(r.Context(), moderator, int32(postId), modActionUnpinPost, modReason(r), func(qtx *sqlc.Queries) error {
+		unpinned, err := qtx.UnpinPost(r.Context(), int32(postId))
+
+		if err == nil && unpinned == 0 {
+			return pgx.ErrNoRows
+		}
+
+		return err
+	})
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Pinned post not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
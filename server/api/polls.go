@@ -0,0 +1,303 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const minPollOptions = 2
+const maxPollOptions = 10
+const maxSymbolsForPollQuestion = 300
+const maxSymbolsForPollOption = 100
+
+// Optional part of the CreatePostReq
+type CreatePollReq struct {
+	Question string     `json:"question"`
+	Options  []string   `json:"options"`
+	Multiple bool       `json:"multiple"` // More than one option per vote
+	ClosesAt *time.Time `json:"closesAt"` // Open until the thread is archived when empty
+}
+
+// Trims the texts in place
+func validatePoll(poll *CreatePollReq) error {
+	poll.Question = strings.TrimSpace(poll.Question)
+
+	if poll.Question == "" {
+		return errors.New("'poll.question' is empty")
+	}
+
+	if len(poll.Question) > maxSymbolsForPollQuestion {
+		return fmt.Errorf("'poll.question' max length is %d", maxSymbolsForPollQuestion)
+	}
+
+	if len(poll.Options) < minPollOptions || len(poll.Options) > maxPollOptions {
+		return fmt.Errorf("'poll.options' must have %d to %d options", minPollOptions, maxPollOptions)
+	}
+
+	for i := range poll.Options {
+		poll.Options[i] = strings.TrimSpace(poll.Options[i])
+
+		if poll.Options[i] == "" {
+			return fmt.Errorf("'poll.options[%d]' is empty", i)
+		}
+
+		if len(poll.Options[i]) > maxSymbolsForPollOption {
+			return fmt.Errorf("'poll.options[%d]' max length is %d", i, maxSymbolsForPollOption)
+		}
+	}
+
+	if poll.ClosesAt != nil && !poll.ClosesAt.After(time.Now()) {
+		return errors.New("'poll.closesAt' is in the past")
+	}
+
+	return nil
+}
+
+// Runs the question and the options through the content filter in place, the
+// strictest visibility among them applies to the post
+func filterPoll(requestId string, poll *CreatePollReq, result *filterResult) error {
+	texts := []*string{&poll.Question}
+
+	for i := range poll.Options {
+		texts = append(texts, &poll.Options[i])
+	}
+
+	for _, text := range texts {
+		filtered, err := filter.apply(requestId, *text)
+
+		if err != nil {
+			return err
+		}
+
+		*text = filtered.Content
+
+		if filtered.Visibility == visibilityShadow || (filtered.Visibility == visibilityHeld && result.Visibility == visibilityVisible) {
+			result.Visibility = filtered.Visibility
+		}
+	}
+
+	return nil
+}
+
+// Text of a post with a poll for checkDuplicate, the content followed by the poll
+func pollText(content string, poll *CreatePollReq) string {
+	return strings.TrimSpace(strings.Join(append([]string{content, poll.Question}, poll.Options...), "\n"))
+}
+
+// Creates the post and its poll in one transaction, returns the poll as GetPosts does, nil when there is none
+func insertPost(ctx context.Context, params sqlc.CreatePostParams, poll *CreatePollReq) (sqlc.Post, json.RawMessage, error) {
+	if poll == nil {
+		post, err := db.Query.CreatePost(ctx, params)
+		return post, nil, err
+	}
+
+	tx, err := db.Pool.Begin(ctx)
+
+	if err != nil {
+		return sqlc.Post{}, nil, err
+	}
+
+	defer tx.Rollback(ctx)
+
+	qtx := db.Query.WithTx(tx)
+
+	post, err := qtx.CreatePost(ctx, params)
+
+	if err != nil {
+		return post, nil, err
+	}
+
+	pollParams := sqlc.CreatePollParams{
+		Post:     post.ID,
+		Question: poll.Question,
+		Options:  poll.Options,
+		Multiple: poll.Multiple,
+	}
+
+	if poll.ClosesAt != nil {
+		pollParams.ClosesAt = pgtype.Timestamptz{Time: *poll.ClosesAt, Valid: true}
+	}
+
+	_, err = qtx.CreatePoll(ctx, pollParams)
+
+	if err != nil {
+		return post, nil, err
+	}
+
+	results, err := qtx.GetPollResults(ctx, sqlc.GetPollResultsParams{
+		Viewer: params.Author,
+		Post:   post.ID,
+	})
+
+	if err != nil {
+		return post, nil, err
+	}
+
+	return post, results, tx.Commit(ctx)
+}
+
+// Votes are final, responds with the results including the vote
+func VotePoll(w http.ResponseWriter, r *http.Request) {
+	type VotePollReq struct {
+		Options []int16 `json:"options"` // Indexes of the options, one unless the poll is multiple choice
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched VotePoll route"))
+
+	postIdStr := chi.URLParam(r, "postId")
+
+	postId, err := strconv.Atoi(postIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'postId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	var req VotePollReq
+
+	err = json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	author := r.Context().Value("author").(uuid.UUID)
+
+	poll, err := db.Query.GetPoll(r.Context(), int32(postId))
+
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && (poll.DeletedAt.Valid || (poll.Visibility != visibilityVisible && poll.Author != author))) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Poll not found"),
+			cause:     errors.New("Not found"),
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if poll.ArchivedAt.Valid || (poll.ClosesAt.Valid && !poll.ClosesAt.Time.After(time.Now())) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Poll is closed"),
+			cause:     errors.New("Forbidden"),
+			Code:      403,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if len(req.Options) == 0 || (!poll.Multiple && len(req.Options) > 1) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'options' must have one option, or more for a multiple choice poll"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	chosen := make(map[int16]bool)
+
+	for _, option := range req.Options {
+		if option < 0 || int(option) >= len(poll.Options) || chosen[option] {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     fmt.Errorf("'options' must be distinct indexes from 0 to %d", len(poll.Options)-1),
+				cause:     errors.New("Bad request"),
+				Code:      400,
+			}
+			fail(w, errReq)
+			return
+		}
+
+		chosen[option] = true
+	}
+
+	_, err = db.Query.VotePoll(r.Context(), sqlc.VotePollParams{
+		Author:  author,
+		Options: req.Options,
+		Poll:    poll.Post,
+	})
+
+	// Poll was closed since it was checked, or this is the second vote
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Already voted or the poll is closed"),
+			cause:     err,
+			Code:      409,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	results, err := db.Query.GetPollResults(r.Context(), sqlc.GetPollResultsParams{
+		Viewer: author,
+		Post:   poll.Post,
+	})
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(results)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, response: %s", 200, results))
+}
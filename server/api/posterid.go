@@ -0,0 +1,168 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"os"
+	"snakesss/markup"
+	"snakesss/sqlc"
+
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// With ANONYMOUS_IDS author uuids are not returned, every author gets a short id
+// which is the same inside one post and its comments, but differs between posts,
+// so nobody can link what a person wrote in different threads
+var anonymousIds = envBool("ANONYMOUS_IDS")
+
+var posterIdSecret = loadPosterIdSecret()
+
+const posterIdLength = 8
+
+func loadPosterIdSecret() []byte {
+	if secret := os.Getenv("POSTER_ID_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	secret := make([]byte, 32)
+
+	_, err := rand.Read(secret)
+
+	if err != nil {
+		panic(err)
+	}
+
+	if anonymousIds {
+		log.Println("POSTER_ID_SECRET is empty, using random secret, poster ids will change after restart")
+	}
+
+	return secret
+}
+
+func posterId(author uuid.UUID, post int32) string {
+	mac := hmac.New(sha256.New, posterIdSecret)
+	mac.Write(author.Bytes())
+	binary.Write(mac, binary.BigEndian, post)
+
+	return base32.StdEncoding.EncodeToString(mac.Sum(nil))[:posterIdLength]
+}
+
+// Returns either the real author or the poster id, depending on ANONYMOUS_IDS
+func authorIdentity(author uuid.UUID, post int32) (*uuid.UUID, string) {
+	if anonymousIds {
+		return nil, posterId(author, post)
+	}
+
+	return &author, ""
+}
+
+func replyIdentity(replyAuthor pgtype.UUID, post int32) (*pgtype.UUID, string) {
+	if !anonymousIds {
+		return &replyAuthor, ""
+	}
+
+	if !replyAuthor.Valid {
+		return nil, ""
+	}
+
+	return nil, posterId(uuid.UUID(replyAuthor.Bytes), post)
+}
+
+const deletedContent = "[deleted]"
+
+// Deleted comments stay in the thread so replies to them still resolve,
+// but nothing about what was written or who wrote it is returned
+func tombstone() (string, string, pgtype.Text, pgtype.Text) {
+	return deletedContent, markup.Render(deletedContent, nil), pgtype.Text{}, pgtype.Text{}
+}
+
+// Views shadow 'author' fields of the embedded rows, json takes the least nested field
+type postView struct {
+	sqlc.GetPostsRow
+	Author   *uuid.UUID `json:"author,omitempty"`
+	PosterId string     `json:"posterId,omitempty"`
+	IsMine   bool       `json:"isMine"`
+}
+
+func newPostView(row sqlc.GetPostsRow, me uuid.UUID) postView {
+	view := postView{
+		GetPostsRow: row,
+		IsMine:      row.Author == me,
+	}
+
+	view.Author, view.PosterId = authorIdentity(row.Author, row.ID)
+
+	return view
+}
+
+type commentsView struct {
+	sqlc.GetCommentsRow
+	Author             *uuid.UUID   `json:"author,omitempty"`
+	PosterId           string       `json:"posterId,omitempty"`
+	ReplyCommentAuthor *pgtype.UUID `json:"replyCommentAuthor,omitempty"`
+	ReplyPosterId      string       `json:"replyPosterId,omitempty"`
+	IsMine             bool         `json:"isMine"`
+}
+
+func newCommentsView(row sqlc.GetCommentsRow, post int32, me uuid.UUID) commentsView {
+	if row.DeletedAt.Valid {
+		row.Content, row.ContentHtml, row.Name, row.Tripcode = tombstone()
+		row.Attachment = nil
+		row.EditedAt = pgtype.Timestamptz{}
+		row.Quotes = json.RawMessage("[]")
+	}
+
+	view := commentsView{
+		GetCommentsRow: row,
+		IsMine:         row.Author == me && !row.DeletedAt.Valid,
+	}
+
+	if !row.DeletedAt.Valid {
+		view.Author, view.PosterId = authorIdentity(row.Author, post)
+	}
+
+	if !row.ReplyCommentDeleted {
+		view.ReplyCommentAuthor, view.ReplyPosterId = replyIdentity(row.ReplyCommentAuthor, post)
+	}
+
+	return view
+}
+
+type commentView struct {
+	sqlc.GetCommentRow
+	Author             *uuid.UUID   `json:"author,omitempty"`
+	PosterId           string       `json:"posterId,omitempty"`
+	ReplyCommentAuthor *pgtype.UUID `json:"replyCommentAuthor,omitempty"`
+	ReplyPosterId      string       `json:"replyPosterId,omitempty"`
+	IsMine             bool         `json:"isMine"`
+}
+
+func newCommentView(row sqlc.GetCommentRow, me uuid.UUID) commentView {
+	if row.DeletedAt.Valid {
+		row.Content, row.ContentHtml, row.Name, row.Tripcode = tombstone()
+		row.Attachment = nil
+		row.EditedAt = pgtype.Timestamptz{}
+		row.Quotes = json.RawMessage("[]")
+	}
+
+	view := commentView{
+		GetCommentRow: row,
+		IsMine:        row.Author == me && !row.DeletedAt.Valid,
+	}
+
+	if !row.DeletedAt.Valid {
+		view.Author, view.PosterId = authorIdentity(row.Author, row.Post)
+	}
+
+	if !row.ReplyCommentDeleted {
+		view.ReplyCommentAuthor, view.ReplyPosterId = replyIdentity(row.ReplyCommentAuthor, row.Post)
+	}
+
+	return view
+}
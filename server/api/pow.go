@@ -0,0 +1,244 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/bits"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Hashcash-like proof of work. Client takes a challenge from GET /challenge and
+// searches for a solution such that sha256(challenge + ":" + solution) starts with
+// at least 'difficulty' zero bits, then sends 'X-Pow: <challenge>:<solution>'.
+// Challenge is '<expiration>.<difficulty>.<nonce>.<mac>', it is signed, so the
+// server does not store issued challenges, only the solved ones until they expire.
+const powChallengeTTL = 5 * time.Minute
+
+var powDifficulty = envInt("POW_DIFFICULTY", 16)
+var powMaxDifficulty = envInt("POW_MAX_DIFFICULTY", 24)
+
+// Requests per minute to the protected routes, above it difficulty grows by one
+// bit every time the load doubles
+var powLoadThreshold = envInt("POW_LOAD_THRESHOLD", 120)
+
+var powSecret = loadPowSecret()
+
+var usedPowNonces = nonceStore{nonces: map[string]time.Time{}}
+
+func loadPowSecret() []byte {
+	if secret := os.Getenv("POW_SECRET"); secret != "" {
+		return []byte(secret)
+	}
+
+	secret := make([]byte, 32)
+
+	_, err := rand.Read(secret)
+
+	if err != nil {
+		panic(err)
+	}
+
+	log.Println("POW_SECRET is empty, using random secret, challenges are valid only for this process")
+
+	return secret
+}
+
+// Approximates requests per minute with the current and the previous minute counters
+type loadMeter struct {
+	mu       sync.Mutex
+	started  time.Time
+	current  int
+	previous int
+}
+
+var powLoad = loadMeter{started: time.Now()}
+
+func (m *loadMeter) hit() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rotate()
+	m.current++
+}
+
+func (m *loadMeter) rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rotate()
+
+	elapsed := time.Since(m.started).Seconds() / 60
+
+	return float64(m.previous)*(1-elapsed) + float64(m.current)
+}
+
+func (m *loadMeter) rotate() {
+	passed := time.Since(m.started)
+
+	if passed < time.Minute {
+		return
+	}
+
+	if passed < 2*time.Minute {
+		m.previous = m.current
+	} else {
+		m.previous = 0
+	}
+
+	m.current = 0
+	m.started = m.started.Add(passed.Truncate(time.Minute))
+}
+
+func currentPowDifficulty() int {
+	if powDifficulty <= 0 {
+		return 0
+	}
+
+	difficulty := powDifficulty
+	rate := powLoad.rate()
+
+	if powLoadThreshold > 0 && rate > float64(powLoadThreshold) {
+		difficulty += int(math.Log2(rate/float64(powLoadThreshold))) + 1
+	}
+
+	return min(difficulty, max(powMaxDifficulty, powDifficulty))
+}
+
+func signPow(payload string) string {
+	mac := hmac.New(sha256.New, powSecret)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func leadingZeroBits(sum []byte) int {
+	count := 0
+
+	for _, b := range sum {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+
+		count += 8
+	}
+
+	return count
+}
+
+// Checks 'X-Pow' header value and marks the challenge as used
+func verifyPow(header string) error {
+	separator := strings.LastIndex(header, ":")
+
+	if separator == -1 {
+		return errors.New("Proof of work is malformed")
+	}
+
+	challenge, solution := header[:separator], header[separator+1:]
+
+	parts := strings.Split(challenge, ".")
+
+	if len(parts) != 4 {
+		return errors.New("Proof of work is malformed")
+	}
+
+	payload := strings.Join(parts[:3], ".")
+
+	if !hmac.Equal([]byte(signPow(payload)), []byte(parts[3])) {
+		return errors.New("Proof of work challenge is forged")
+	}
+
+	expiration, err := strconv.ParseInt(parts[0], 10, 64)
+
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Unix(expiration, 0)
+
+	if expiresAt.Before(time.Now()) {
+		return errors.New("Proof of work challenge is expired")
+	}
+
+	difficulty, err := strconv.Atoi(parts[1])
+
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256([]byte(challenge + ":" + solution))
+
+	if leadingZeroBits(sum[:]) < difficulty {
+		return errors.New("Proof of work solution is wrong")
+	}
+
+	if !usedPowNonces.use(parts[2], expiresAt) {
+		return errors.New("Proof of work challenge was already used")
+	}
+
+	return nil
+}
+
+func PowChallenge(w http.ResponseWriter, r *http.Request) {
+	type PowChallengeResp struct {
+		Challenge  string    `json:"challenge"`
+		Difficulty int       `json:"difficulty"`
+		ExpiresAt  time.Time `json:"expiresAt"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched PowChallenge route"))
+
+	nonce := make([]byte, 16)
+
+	_, err := rand.Read(nonce)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	difficulty := currentPowDifficulty()
+	expiresAt := time.Now().Add(powChallengeTTL)
+
+	payload := fmt.Sprintf("%d.%d.%s", expiresAt.Unix(), difficulty, base64.RawURLEncoding.EncodeToString(nonce))
+
+	resp := PowChallengeResp{
+		Challenge:  payload + "." + signPow(payload),
+		Difficulty: difficulty,
+		ExpiresAt:  expiresAt,
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, response: %s", 200, marshResp))
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"snakesss/db"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Deleted posts and comments are kept as tombstones for PURGE_RETENTION, so they
+// can be restored, after that the ones nothing depends on anymore are removed
+var purgeRetention = envDuration("PURGE_RETENTION", 30*24*time.Hour)
+var purgeInterval = envDuration("PURGE_INTERVAL", time.Hour)
+
+func StartPurge() {
+	go func() {
+		for range time.Tick(purgeInterval) {
+			if err := purge(context.Background()); err != nil {
+				log.Println(fmt.Sprintf("Purge failed: %s", err))
+			}
+		}
+	}()
+}
+
+func purge(ctx context.Context) error {
+	deletedBefore := pgtype.Timestamptz{Time: time.Now().Add(-purgeRetention), Valid: true}
+
+	var comments int64
+
+	// Removing a reply can free the comment it replied to, repeat until the chains are gone
+	for {
+		purged, err := db.Query.PurgeDeletedComments(ctx, deletedBefore)
+
+		if err != nil {
+			return err
+		}
+
+		if purged == 0 {
+			break
+		}
+
+		comments += purged
+	}
+
+	posts, err := db.Query.PurgeDeletedPosts(ctx, deletedBefore)
+
+	if err != nil {
+		return err
+	}
+
+	if comments > 0 || posts > 0 {
+		log.Println(fmt.Sprintf("Purged %d deleted posts and %d deleted comments", posts, comments))
+	}
+
+	return purgeAttachments(ctx)
+}
+
+// Removes attachments nothing refers to anymore together with their files. A
+// file that fails to delete is only logged, without its row it is not served.
+func purgeAttachments(ctx context.Context) error {
+	createdBefore := pgtype.Timestamptz{Time: time.Now().Add(-purgeInterval), Valid: true}
+
+	attachments, err := db.Query.PurgeUnusedAttachments(ctx, createdBefore)
+
+	if err != nil {
+		return err
+	}
+
+	for _, attachment := range attachments {
+		for _, key := range []string{attachment.FileKey, attachment.ThumbKey} {
+			if err := files.Delete(ctx, key); err != nil {
+				log.Println(fmt.Sprintf("Purge failed to delete file '%s': %s", key, err))
+			}
+		}
+	}
+
+	if len(attachments) > 0 {
+		log.Println(fmt.Sprintf("Purged %d unused attachments", len(attachments)))
+	}
+
+	return nil
+}
@@ -0,0 +1,273 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Token buckets per client ip and, on routes behind AuthMiddleware, per author.
+// RateLimitMiddleware runs before authentication and only knows the ip, so
+// AuthorRateLimitMiddleware charges the same limits to the author after it.
+// Limits are set with RATE_LIMIT_<NAME>=<tokens>/<duration>, e.g. '20/10s' allows
+// bursts of 20 requests and refills one token every 500ms. 'off' disables a limit.
+// A limit of one token is a cooldown, '1/30s' allows one request every 30 seconds.
+const rateLimitSweepInterval = time.Minute
+
+var readLimit = newRateLimiter("read", "120/10s")
+var writeLimit = newRateLimiter("write", "30/10s")
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	name     string
+	capacity float64
+	refill   time.Duration // Time to get one token back
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	swept    time.Time
+}
+
+// Returns nil when the limit is disabled
+func newRateLimiter(name string, def string) *rateLimiter {
+	env := "RATE_LIMIT_" + strings.ToUpper(name)
+	spec := os.Getenv(env)
+
+	if spec == "" {
+		spec = def
+	}
+
+	if spec == "off" {
+		return nil
+	}
+
+	capacity, refill, err := parseRateLimit(spec)
+
+	if err != nil {
+		log.Println(fmt.Sprintf("Invalid rate limit in %s (%s), using default", env, err))
+		capacity, refill, _ = parseRateLimit(def)
+	}
+
+	return &rateLimiter{
+		name:     name,
+		capacity: float64(capacity),
+		refill:   refill,
+		buckets:  map[string]*bucket{},
+		swept:    time.Now(),
+	}
+}
+
+func parseRateLimit(spec string) (int, time.Duration, error) {
+	tokensStr, periodStr, ok := strings.Cut(spec, "/")
+
+	if !ok {
+		return 0, 0, errors.New("Expected <tokens>/<duration>")
+	}
+
+	tokens, err := strconv.Atoi(tokensStr)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	period, err := time.ParseDuration(periodStr)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if tokens < 1 || period <= 0 {
+		return 0, 0, errors.New("Tokens and duration must be positive")
+	}
+
+	return tokens, period / time.Duration(tokens), nil
+}
+
+// Takes a token from every bucket of keys, or none of them if one is empty, in
+// that case returns how long to wait until all of them have a token
+func (l *rateLimiter) take(keys ...string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	// Full buckets are the same as missing ones
+	if now.Sub(l.swept) > rateLimitSweepInterval {
+		full := time.Duration(l.capacity * float64(l.refill))
+
+		for key, b := range l.buckets {
+			if now.Sub(b.last) > full {
+				delete(l.buckets, key)
+			}
+		}
+
+		l.swept = now
+	}
+
+	var wait time.Duration
+
+	for _, key := range keys {
+		b, ok := l.buckets[key]
+
+		if !ok {
+			b = &bucket{tokens: l.capacity, last: now}
+			l.buckets[key] = b
+		}
+
+		b.tokens = math.Min(l.capacity, b.tokens+float64(now.Sub(b.last))/float64(l.refill))
+		b.last = now
+
+		if b.tokens < 1 {
+			wait = max(wait, time.Duration((1-b.tokens)*float64(l.refill)))
+		}
+	}
+
+	if wait > 0 {
+		return false, wait
+	}
+
+	for _, key := range keys {
+		l.buckets[key].tokens--
+	}
+
+	return true, 0
+}
+
+// Returns the tokens of a request that turned out not to count
+func (l *rateLimiter) give(keys ...string) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if b, ok := l.buckets[key]; ok {
+			b.tokens = math.Min(l.capacity, b.tokens+1)
+		}
+	}
+}
+
+// Ip of the client and, once authenticated, the author
+func rateLimitKeys(r *http.Request) []string {
+	keys := []string{ipKey(r)}
+
+	if author, ok := r.Context().Value("author").(uuid.UUID); ok {
+		keys = append(keys, "author:"+author.String())
+	}
+
+	return keys
+}
+
+func ipKey(r *http.Request) string {
+	return "ip:" + r.Context().Value("clientIp").(netip.Addr).String()
+}
+
+func (l *rateLimiter) serve(h http.Handler, w http.ResponseWriter, r *http.Request, keys ...string) {
+	if l == nil {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	ok, wait := l.take(keys...)
+
+	if ok {
+		h.ServeHTTP(w, r)
+		return
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	retryAfter := int(math.Ceil(wait.Seconds()))
+
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	errReq := RequestError{
+		RequestId: requestId,
+		error:     fmt.Errorf("Too many requests, retry in %d seconds", retryAfter),
+		cause:     fmt.Errorf("Rate limit '%s' exceeded", l.name),
+		Code:      429,
+	}
+	fail(w, errReq)
+}
+
+// Reads and writes of all routes are limited separately
+func RateLimitMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methodLimit(r).serve(h, w, r, ipKey(r))
+	})
+}
+
+// Same limits as RateLimitMiddleware for the author, goes after AuthMiddleware
+func AuthorRateLimitMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		author := r.Context().Value("author").(uuid.UUID)
+		methodLimit(r).serve(h, w, r, "author:"+author.String())
+	})
+}
+
+func methodLimit(r *http.Request) *rateLimiter {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return readLimit
+	default:
+		return writeLimit
+	}
+}
+
+// Limit of a single route, on top of RateLimitMiddleware
+func RateLimit(name string, def string) func(http.Handler) http.Handler {
+	limiter := newRateLimiter(name, def)
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limiter.serve(h, w, r, rateLimitKeys(r)...)
+		})
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Limit of a single route that only counts successful requests, so a cooldown
+// is not spent on a post that was rejected. The token is still taken up front,
+// concurrent requests can't get past it while the first one runs.
+func Cooldown(name string, def string) func(http.Handler) http.Handler {
+	limiter := newRateLimiter(name, def)
+
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			keys := rateLimitKeys(r)
+
+			limiter.serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+				h.ServeHTTP(sw, r)
+
+				if sw.status >= 300 {
+					limiter.give(keys...)
+				}
+			}), w, r, keys...)
+		})
+	}
+}
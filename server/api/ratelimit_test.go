@@ -0,0 +1,92 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func testRateLimiter(capacity float64, refill time.Duration) *rateLimiter {
+	return &rateLimiter{
+		name:     "test",
+		capacity: capacity,
+		refill:   refill,
+		buckets:  map[string]*bucket{},
+		swept:    time.Now(),
+	}
+}
+
+func TestRateLimiterTake(t *testing.T) {
+	type take struct {
+		keys []string
+		ok   bool
+	}
+
+	tests := []struct {
+		name     string
+		capacity float64
+		takes    []take
+	}{
+		{"burst", 2, []take{{[]string{"a"}, true}, {[]string{"a"}, true}, {[]string{"a"}, false}}},
+		{"keys are separate", 1, []take{{[]string{"a"}, true}, {[]string{"b"}, true}, {[]string{"a"}, false}}},
+		{"all or none", 1, []take{{[]string{"a"}, true}, {[]string{"a", "b"}, false}, {[]string{"b"}, true}}},
+		{"all keys are charged", 1, []take{{[]string{"a", "b"}, true}, {[]string{"b"}, false}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter := testRateLimiter(test.capacity, time.Hour)
+
+			for i, take := range test.takes {
+				ok, wait := limiter.take(take.keys...)
+
+				if ok != take.ok {
+					t.Fatalf("take %d of %v = %t, want %t", i, take.keys, ok, take.ok)
+				}
+
+				if ok != (wait == 0) {
+					t.Errorf("take %d of %v waits %s", i, take.keys, wait)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	limiter := testRateLimiter(1, time.Hour)
+	limiter.take("a")
+
+	ok, wait := limiter.take("a")
+
+	if ok || wait <= 59*time.Minute || wait > time.Hour {
+		t.Fatalf("take = %t, %s, want to wait about an hour", ok, wait)
+	}
+
+	// Half a token back
+	limiter.buckets["a"].last = limiter.buckets["a"].last.Add(-30 * time.Minute)
+
+	ok, wait = limiter.take("a")
+
+	if ok || wait <= 29*time.Minute || wait > 30*time.Minute {
+		t.Fatalf("take = %t, %s, want to wait about half an hour", ok, wait)
+	}
+
+	limiter.buckets["a"].last = limiter.buckets["a"].last.Add(-time.Hour)
+
+	if ok, _ = limiter.take("a"); !ok {
+		t.Fatal("take after a refill was refused")
+	}
+}
+
+func TestRateLimiterGive(t *testing.T) {
+	limiter := testRateLimiter(1, time.Hour)
+	limiter.take("a")
+	limiter.give("a")
+
+	if ok, _ := limiter.take("a"); !ok {
+		t.Fatal("take after give was refused")
+	}
+
+	// Disabled limits are nil
+	var disabled *rateLimiter
+	disabled.give("a")
+}
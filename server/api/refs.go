@@ -0,0 +1,111 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"snakesss/db"
+	"snakesss/markup"
+	"snakesss/sqlc"
+
+	"github.com/gofrs/uuid"
+)
+
+const maxRefsPerComment = 10
+
+// Same as the items of 'quotes' and 'backlinks' in GetComments
+type commentRef struct {
+	ID   int32 `json:"id"`
+	Post int32 `json:"post"`
+}
+
+// Finds the '>>123' references in the content, fails the request unless all of
+// them are comments the author can see. Returns the posts of the referenced
+// comments for markup.Render and the references ordered by id.
+func resolveRefs(w http.ResponseWriter, r *http.Request, content string, self int32) (map[int32]int32, []commentRef, bool) {
+	requestId := r.Context().Value("requestId").(string)
+
+	ids := markup.Refs(content)
+
+	if len(ids) == 0 {
+		return nil, make([]commentRef, 0), true
+	}
+
+	if len(ids) > maxRefsPerComment {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     fmt.Errorf("'content' can reference at most %d comments", maxRefsPerComment),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return nil, nil, false
+	}
+
+	targets, err := db.Query.GetRefTargets(r.Context(), sqlc.GetRefTargetsParams{
+		Ids:    ids,
+		Self:   self,
+		Author: r.Context().Value("author").(uuid.UUID),
+	})
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return nil, nil, false
+	}
+
+	posts := make(map[int32]int32)
+	refs := make([]commentRef, 0, len(targets))
+
+	for _, target := range targets {
+		posts[target.ID] = target.Post
+		refs = append(refs, commentRef{ID: target.ID, Post: target.Post})
+	}
+
+	for _, id := range ids {
+		if _, ok := posts[id]; !ok {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     fmt.Errorf("'>>%d' is not a comment", id),
+				cause:     errors.New("Bad request"),
+				Code:      400,
+			}
+			fail(w, errReq)
+			return nil, nil, false
+		}
+	}
+
+	slices.SortFunc(refs, func(a, b commentRef) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+
+	return posts, refs, true
+}
+
+// Replaces the references of the comment, runs in the transaction that writes its content
+func saveRefs(ctx context.Context, qtx *sqlc.Queries, comment int32, refs []commentRef) error {
+	err := qtx.DeleteCommentRefs(ctx, comment)
+
+	if err != nil || len(refs) == 0 {
+		return err
+	}
+
+	targets := make([]int32, 0, len(refs))
+
+	for _, ref := range refs {
+		targets = append(targets, ref.ID)
+	}
+
+	return qtx.CreateCommentRefs(ctx, sqlc.CreateCommentRefsParams{
+		Comment: comment,
+		Targets: targets,
+	})
+}
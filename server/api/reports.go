@@ -0,0 +1,585 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var reportReasons = []string{"spam", "abuse", "illegal", "off_topic", "other"}
+
+const (
+	resolutionDismiss = "dismiss"
+	resolutionDelete  = "delete"
+	resolutionBan     = "ban" // Deletes the target too
+)
+
+var resolutions = []string{resolutionDismiss, resolutionDelete, resolutionBan}
+
+const maxReportDetailsLength = 500
+const reportsPerLoad = 50
+
+// Exactly one of post or comment is valid
+func createReport(w http.ResponseWriter, r *http.Request, post pgtype.Int4, comment pgtype.Int4) {
+	type ReportReq struct {
+		Reason  string `json:"reason"`
+		Details string `json:"details"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+
+	var req ReportReq
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if !slices.Contains(reportReasons, req.Reason) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     fmt.Errorf("'reason' must be one of: %s", strings.Join(reportReasons, ", ")),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	req.Details = strings.TrimSpace(req.Details)
+
+	if len([]rune(req.Details)) > maxReportDetailsLength {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     fmt.Errorf("'details' is longer than %d characters", maxReportDetailsLength),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	_, err = targetAuthor(r.Context(), db.Query, post, comment)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	author := r.Context().Value("author").(uuid.UUID)
+
+	_, err = db.Query.CreateReport(r.Context(), sqlc.CreateReportParams{
+		Author:  author,
+		Post:    post,
+		Comment: comment,
+		Reason:  req.Reason,
+		Details: pgtype.Text{String: req.Details, Valid: req.Details != ""},
+	})
+
+	// Conflict, the author already reported it
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Already reported"),
+			cause:     err,
+			Code:      409,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
+
+func targetAuthor(ctx context.Context, q *sqlc.Queries, post pgtype.Int4, comment pgtype.Int4) (uuid.UUID, error) {
+	if post.Valid {
+		return q.GetPostAuthor(ctx, post.Int32)
+	}
+
+	return q.GetCommentAuthor(ctx, comment.Int32)
+}
+
+// Type, id and row of the reported post or comment for the moderation log
+func reportTarget(ctx context.Context, q *sqlc.Queries, post pgtype.Int4, comment pgtype.Int4) (string, string, any, error) {
+	if post.Valid {
+		row, err := q.GetPostById(ctx, post.Int32)
+
+		return targetTypePost, strconv.Itoa(int(post.Int32)), row, err
+	}
+
+	row, err := q.GetCommentById(ctx, comment.Int32)
+
+	return targetTypeComment, strconv.Itoa(int(comment.Int32)), row, err
+}
+
+func ReportPost(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched ReportPost route"))
+
+	postIdStr := chi.URLParam(r, "postId")
+
+	postId, err := strconv.Atoi(postIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'postId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	createReport(w, r, pgtype.Int4{Int32: int32(postId), Valid: true}, pgtype.Int4{})
+}
+
+func ReportComment(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched ReportComment route"))
+
+	commentIdStr := chi.URLParam(r, "commentId")
+
+	commentId, err := strconv.Atoi(commentIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'commentId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	createReport(w, r, pgtype.Int4{}, pgtype.Int4{Int32: int32(commentId), Valid: true})
+}
+
+func GetReports(w http.ResponseWriter, r *http.Request) {
+	type GetReportsResp struct {
+		NextOffset *int                     `json:"nextOffset"`
+		Reports    []sqlc.GetOpenReportsRow `json:"reports"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched GetReports route"))
+
+	offsetStr := r.URL.Query().Get("offset")
+
+	var offset int32
+
+	if offsetStr != "" {
+		offset64, err := strconv.Atoi(offsetStr)
+
+		offset = int32(offset64)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("'offset' is not a number"),
+				cause:     errors.New("Bad request"),
+				Code:      400,
+			}
+			fail(w, errReq)
+			return
+		}
+	}
+
+	reports, err := db.Query.GetOpenReports(r.Context(), sqlc.GetOpenReportsParams{
+		Limit:  reportsPerLoad,
+		Offset: offset,
+	})
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	var nextOffset *int
+
+	if len(reports) >= reportsPerLoad {
+		temp := int(offset) + reportsPerLoad
+		nextOffset = &temp
+	}
+
+	resp := GetReportsResp{
+		NextOffset: nextOffset,
+		Reports:    reports,
+	}
+
+	if resp.Reports == nil {
+		resp.Reports = make([]sqlc.GetOpenReportsRow, 0)
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", 200))
+}
+
+// Closes every open report of the target and applies the resolution in one transaction
+func resolveReports(w http.ResponseWriter, r *http.Request, post pgtype.Int4, comment pgtype.Int4) {
+	type ResolveReportsReq struct {
+		Action    string     `json:"action"`
+		Reason    string     `json:"reason"`    // Ban reason, also goes to the moderation log
+		ExpiresAt *time.Time `json:"expiresAt"` // Ban expiry, permanent when empty
+	}
+	type ResolveReportsResp struct {
+		Resolved int64     `json:"resolved"`
+		Ban      *sqlc.Ban `json:"ban"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+
+	var req ResolveReportsReq
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if !slices.Contains(resolutions, req.Action) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     fmt.Errorf("'action' must be one of: %s", strings.Join(resolutions, ", ")),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	moderator := r.Context().Value("author").(uuid.UUID)
+
+	tx, err := db.Pool.Begin(r.Context())
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	defer tx.Rollback(r.Context())
+
+	qtx := db.Query.WithTx(tx)
+
+	resolved, err := qtx.ResolveReports(r.Context(), sqlc.ResolveReportsParams{
+		ResolvedBy: pgtype.UUID{Bytes: moderator, Valid: true},
+		Resolution: pgtype.Text{String: req.Action, Valid: true},
+		Post:       post,
+		Comment:    comment,
+	})
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if resolved == 0 {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("No open reports"),
+			cause:     errors.New("Not found"),
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	targetType, targetId, before, err := reportTarget(r.Context(), qtx, post, comment)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	resp := ResolveReportsResp{
+		Resolved: resolved,
+	}
+
+	if req.Action == resolutionBan {
+		author, err := targetAuthor(r.Context(), qtx, post, comment)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("Internal server error"),
+				cause:     err,
+				Code:      500,
+			}
+			fail(w, errReq)
+			return
+		}
+
+		reason := strings.TrimSpace(req.Reason)
+
+		if reason == "" {
+			reason = "Reported content"
+		}
+
+		params := sqlc.CreateBanParams{
+			Author:    pgtype.UUID{Bytes: author, Valid: true},
+			Reason:    reason,
+			CreatedBy: moderator,
+		}
+
+		if req.ExpiresAt != nil {
+			params.ExpiresAt = pgtype.Timestamptz{Time: *req.ExpiresAt, Valid: true}
+		}
+
+		ban, err := qtx.CreateBan(r.Context(), params)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("Internal server error"),
+				cause:     err,
+				Code:      500,
+			}
+			fail(w, errReq)
+			return
+		}
+
+		resp.Ban = &ban
+
+		err = logModAction(r.Context(), qtx, moderator, modAction{
+			Action:     modActionBan,
+			TargetType: targetTypeBan,
+			TargetId:   strconv.Itoa(int(ban.ID)),
+			Reason:     ban.Reason,
+			After:      ban,
+		})
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("Internal server error"),
+				cause:     err,
+				Code:      500,
+			}
+			fail(w, errReq)
+			return
+		}
+	}
+
+	if req.Action == resolutionDelete || req.Action == resolutionBan {
+		deletedBy := pgtype.UUID{Bytes: moderator, Valid: true}
+
+		if post.Valid {
+			_, err = qtx.DeletePost(r.Context(), sqlc.DeletePostParams{ID: post.Int32, DeletedBy: deletedBy})
+		} else {
+			_, err = qtx.DeleteComment(r.Context(), sqlc.DeleteCommentParams{ID: comment.Int32, DeletedBy: deletedBy})
+		}
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     errors.New("Internal server error"),
+				cause:     err,
+				Code:      500,
+			}
+			fail(w, errReq)
+			return
+		}
+	}
+
+	_, _, after, err := reportTarget(r.Context(), qtx, post, comment)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	err = logModAction(r.Context(), qtx, moderator, modAction{
+		Action:     modActionResolveReports,
+		TargetType: targetType,
+		TargetId:   targetId,
+		Reason:     strings.TrimSuffix(req.Action+": "+strings.TrimSpace(req.Reason), ": "),
+		Before:     before,
+		After:      after,
+	})
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	err = tx.Commit(r.Context())
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, response: %s", 200, marshResp))
+}
+
+func ResolvePostReports(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched ResolvePostReports route"))
+
+	postIdStr := chi.URLParam(r, "postId")
+
+	postId, err := strconv.Atoi(postIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'postId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	resolveReports(w, r, pgtype.Int4{Int32: int32(postId), Valid: true}, pgtype.Int4{})
+}
+
+func ResolveCommentReports(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched ResolveCommentReports route"))
+
+	commentIdStr := chi.URLParam(r, "commentId")
+
+	commentId, err := strconv.Atoi(commentIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'commentId' is not a number"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	resolveReports(w, r, pgtype.Int4{}, pgtype.Int4{Int32: int32(commentId), Valid: true})
+}
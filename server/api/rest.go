@@ -1,24 +1,23 @@
 package api
 
 import (
-	"crypto/ecdsa"
-	"crypto/elliptic"
-	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/netip"
+	"slices"
 	"snakesss/db"
+	"snakesss/markup"
 	"snakesss/sqlc"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gofrs/uuid"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
@@ -29,8 +28,6 @@ const commentsPerLoad = 15
 const maxSymbolsForPost = 10000
 const maxSymbolsForComment = 10000
 
-var jwtKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
-
 type RequestError struct {
 	RequestId string
 	error     error // Error that client see
@@ -62,34 +59,37 @@ func fail(w http.ResponseWriter, err RequestError) {
 	return
 }
 
-func Auth(w http.ResponseWriter, r *http.Request) {
-	type AuthResp struct {
-		Token string `json:"token"`
-		Uuid  string `json:"uuid"`
-	}
+// Whether the query failed with the given postgres error code, like 23505 for a unique violation
+func isPgError(err error, code string) bool {
+	var pgErr *pgconn.PgError
 
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
+
+func Auth(w http.ResponseWriter, r *http.Request) {
 	requestId := r.Context().Value("requestId").(string)
 	requestLog(requestId, fmt.Sprintf("Request matched Auth route"))
 
-    ip := r.Header.Get("X-Real-Ip") // Client ip from proxy
+	ip := r.Context().Value("clientIp").(netip.Addr)
 
-	if ip == "" {
+	if !ip.IsValid() {
 		errReq := RequestError{
 			RequestId: requestId,
-			error:     errors.New("No remove ip found"),
-			cause:     errors.New("Proxy did not provided 'X-Real-Ip header'"),
+			error:     errors.New("No remote ip found"),
+			cause:     errors.New("Client ip could not be resolved"),
 			Code:      500,
 		}
-        fail(w, errReq)
+		fail(w, errReq)
 		return
 	}
 
-	ipnet, err := netip.ParseAddr(ip)
+	// Banned ips get no author, nil uuid matches no author ban
+	ban, err := activeBan(r.Context(), uuid.Nil, ip)
 
 	if err != nil {
 		errReq := RequestError{
 			RequestId: requestId,
-			error:     errors.New("Parsing ip address failed"),
+			error:     errors.New("Internal server error"),
 			cause:     err,
 			Code:      500,
 		}
@@ -97,7 +97,12 @@ func Auth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	author, err := db.Query.Auth(r.Context(), ipnet)
+	if ban != nil {
+		failBanned(w, requestId, ban)
+		return
+	}
+
+	author, err := db.Query.Auth(r.Context(), &ip)
 
 	if err != nil {
 		errReq := RequestError{
@@ -110,18 +115,31 @@ func Auth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
-		"exp":  time.Now().Add(time.Hour * 48).Unix(),
-		"uuid": author.ID,
-		"ip":   author.Ip,
-	})
+	// Author bans only exist for authors that were there before
+	ban, err = activeBan(r.Context(), author.ID, ip)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
 
-	sign, err := token.SignedString(jwtKey)
+	if ban != nil {
+		failBanned(w, requestId, ban)
+		return
+	}
+
+	family, err := uuid.NewV4()
 
 	if err != nil {
 		errReq := RequestError{
 			RequestId: requestId,
-			error:     errors.New("Parsing ip address failed"),
+			error:     errors.New("Internal server error"),
 			cause:     err,
 			Code:      500,
 		}
@@ -129,9 +147,17 @@ func Auth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := AuthResp{
-		Token: sign,
-		Uuid:  author.ID.String(),
+	resp, err := issueTokens(r.Context(), db.Query, author, family)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
 	}
 
 	marshResp, err := json.Marshal(resp)
@@ -148,23 +174,48 @@ func Auth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Write(marshResp)
-	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, response: %s", 200, marshResp))
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d, author: %s", 200, resp.Uuid))
 }
 
 func GetPosts(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched GetPosts route"))
+
+	getPosts(w, r, false, pgtype.Int4{})
+}
+
+func GetArchivedPosts(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched GetArchivedPosts route"))
+
+	getPosts(w, r, true, pgtype.Int4{})
+}
+
+// Board is empty for the listing of all boards
+func getPosts(w http.ResponseWriter, r *http.Request, archived bool, board pgtype.Int4) {
 	type GetPostsResp struct {
-		NextOffset *int               `json:"nextOffset"`
-		Posts      []sqlc.GetPostsRow `json:"posts"`
+		NextOffset *int       `json:"nextOffset"`
+		Posts      []postView `json:"posts"`
 	}
 
 	requestId := r.Context().Value("requestId").(string)
-	requestLog(requestId, fmt.Sprintf("Request matched GetPosts route"))
 
 	author := r.Context().Value("author").(uuid.UUID)
 
 	offsetStr := r.URL.Query().Get("offset")
 	search := r.URL.Query().Get("search")
 
+	if anonymousIds && strings.HasPrefix(search, "@") {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Search by author is disabled"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
 	var offset int32
 
 	if offsetStr != "" {
@@ -203,6 +254,8 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 		Author:   author,
 		Offset:   offset,
 		Limit:    postsPerLoad,
+		Archived: archived,
+		Board:    board,
 		Search:   search,
 		DateAsc:  dateAsc,
 		DateDesc: dateDesc,
@@ -229,11 +282,11 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 
 	resp := GetPostsResp{
 		NextOffset: nextOffset,
-		Posts:      posts,
+		Posts:      make([]postView, 0, len(posts)),
 	}
 
-	if resp.Posts == nil {
-		resp.Posts = make([]sqlc.GetPostsRow, 0)
+	for _, post := range posts {
+		resp.Posts = append(resp.Posts, newPostView(post, author))
 	}
 
 	marshResp, err := json.Marshal(resp)
@@ -254,51 +307,203 @@ func GetPosts(w http.ResponseWriter, r *http.Request) {
 }
 
 func CreatePost(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched CreatePost route"))
+
+	board, err := db.Query.GetBoard(r.Context(), defaultBoard)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     fmt.Errorf("Default board '%s': %w", defaultBoard, err),
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	createPost(w, r, board)
+}
+
+func createPost(w http.ResponseWriter, r *http.Request, board sqlc.Board) {
 	type CreatePostReq struct {
-		Content string `json:"content"`
+		Content string         `json:"content"`
+		Name    string         `json:"name"` // 'name' or 'name#secret' for a tripcode
+		Poll    *CreatePollReq `json:"poll"`
 	}
 	//Copied some of the sqlc fields, because embedded struct will not be flattened in json
 	type CreatePostResp struct {
 		ID            int32              `json:"id"`
-		Author        uuid.UUID          `json:"author"`
+		Board         string             `json:"board"`
+		Author        *uuid.UUID         `json:"author,omitempty"`
+		PosterId      string             `json:"posterId,omitempty"`
 		CreatedAt     pgtype.Timestamptz `json:"createdAt"`
 		Content       string             `json:"content"`
+		ContentHtml   string             `json:"contentHtml"`
+		Name          pgtype.Text        `json:"name"`
+		Tripcode      pgtype.Text        `json:"tripcode"`
 		LikesCount    int                `json:"likesCount"`
 		CommentsCount int                `json:"commentsCount"`
 		IsLiked       bool               `json:"isLiked"`
+		IsMine        bool               `json:"isMine"`
+		IsHeld        bool               `json:"isHeld"` // Waits for a moderator, shadowed content is not reported
+		Attachment    *sqlc.Attachment   `json:"attachment"`
+		Poll          json.RawMessage    `json:"poll"`
 	}
 
 	requestId := r.Context().Value("requestId").(string)
-	requestLog(requestId, fmt.Sprintf("Request matched CreatePost route"))
+
+	settings := parseBoardSettings(board)
+
+	if settings.ReadOnly && !slices.Contains([]string{RoleModerator, RoleAdmin}, r.Context().Value("role").(string)) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Board is read only"),
+			cause:     errors.New("Forbidden"),
+			Code:      403,
+		}
+		fail(w, errReq)
+		return
+	}
 
 	var post CreatePostReq
 
-	err := json.NewDecoder(r.Body).Decode(&post)
+	upload, err := decodeBody(r, &post)
 
 	if err != nil {
+		failBody(w, requestId, err)
+		return
+	}
+
+	// An image or a poll is enough for a post
+	if len(post.Content) == 0 && upload == nil && post.Poll == nil {
+		errReq := RequestError{
+            RequestId: requestId,
+			error:     errors.New("'content' is empty"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	} else if len(post.Content) > maxSymbolsForPost {
 		errReq := RequestError{
 			RequestId: requestId,
-			error:     errors.New("Body is invalid"),
-			cause:     err,
+			error:     errors.New(fmt.Sprintf("'content' max length is %d", maxSymbolsForPost)),
+			cause:     errors.New("Bad request"),
 			Code:      400,
 		}
 		fail(w, errReq)
 		return
 	}
 
-	if len(post.Content) == 0 {
+
+	if post.Poll != nil {
+		err = validatePoll(post.Poll)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     err,
+				cause:     errors.New("Bad request"),
+				Code:      400,
+			}
+			fail(w, errReq)
+			return
+		}
+	}
+
+	filtered, err := filter.apply(requestId, strings.TrimSpace(post.Content))
+
+	if err != nil {
 		errReq := RequestError{
-            RequestId: requestId,
+			RequestId: requestId,
+			error:     err,
+			cause:     errors.New("Rejected by content filter"),
+			Code:      422,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	// A replace rule can leave nothing of the content
+	if filtered.Content == "" && upload == nil && post.Poll == nil {
+		errReq := RequestError{
+			RequestId: requestId,
 			error:     errors.New("'content' is empty"),
 			cause:     errors.New("Bad request"),
 			Code:      400,
 		}
 		fail(w, errReq)
 		return
-	} else if len(post.Content) > maxSymbolsForPost {
+	}
+
+	if post.Poll != nil {
+		err = filterPoll(requestId, post.Poll, &filtered)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     err,
+				cause:     errors.New("Rejected by content filter"),
+				Code:      422,
+			}
+			fail(w, errReq)
+			return
+		}
+
+		// Replacements can leave a question or an option empty
+		err = validatePoll(post.Poll)
+
+		if err != nil {
+			errReq := RequestError{
+				RequestId: requestId,
+				error:     err,
+				cause:     errors.New("Bad request"),
+				Code:      400,
+			}
+			fail(w, errReq)
+			return
+		}
+	}
+
+	// Poll is part of what makes a post a near-duplicate
+	checked := filtered
+
+	if post.Poll != nil {
+		checked.Content = pollText(filtered.Content, post.Poll)
+	}
+
+	fingerprint, err := checkDuplicate(r.Context(), requestId, &checked, 0, 0)
+
+	if errors.Is(err, errNearDuplicate) {
 		errReq := RequestError{
 			RequestId: requestId,
-			error:     errors.New(fmt.Sprintf("'content' max length is %d", maxSymbolsForPost)),
+			error:     err,
+			cause:     errors.New("Near-duplicate content"),
+			Code:      409,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	name, tripcode, err := parseName(post.Name)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     err,
 			cause:     errors.New("Bad request"),
 			Code:      400,
 		}
@@ -306,14 +511,34 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var attachment *sqlc.Attachment
+
+	if upload != nil {
+		saved, err := saveAttachment(r.Context(), upload)
+
+		if err != nil {
+			failAttachment(w, requestId, err)
+			return
+		}
+
+		attachment = &saved
+	}
+
 	author := r.Context().Value("author").(uuid.UUID)
 
 	params := sqlc.CreatePostParams{
-		Author:  author,
-		Content: strings.TrimSpace(post.Content),
+		Author:      author,
+		Content:     filtered.Content,
+		Name:        name,
+		Tripcode:    tripcode,
+		Visibility:  checked.Visibility,
+		Fingerprint: fingerprint,
+		Board:       board.ID,
+		ContentHtml: markup.Render(filtered.Content, nil),
+		Attachment:  attachmentHash(attachment),
 	}
 
-	createdPost, err := db.Query.CreatePost(r.Context(), params)
+	createdPost, poll, err := insertPost(r.Context(), params, post.Poll)
 
 	if err != nil {
 		errReq := RequestError{
@@ -328,14 +553,23 @@ func CreatePost(w http.ResponseWriter, r *http.Request) {
 
 	filled := CreatePostResp{
 		ID:            createdPost.ID,
-		Author:        createdPost.Author,
+		Board:         board.Slug,
 		CreatedAt:     createdPost.CreatedAt,
 		Content:       createdPost.Content,
+		ContentHtml:   createdPost.ContentHtml,
+		Name:          createdPost.Name,
+		Tripcode:      createdPost.Tripcode,
 		LikesCount:    0,
 		CommentsCount: 0,
 		IsLiked:       false,
+		IsMine:        true,
+		IsHeld:        createdPost.Visibility == visibilityHeld,
+		Attachment:    attachment,
+		Poll:          poll,
 	}
 
+	filled.Author, filled.PosterId = authorIdentity(createdPost.Author, createdPost.ID)
+
 	marshResp, err := json.Marshal(filled)
 
 	if err != nil {
@@ -376,6 +610,28 @@ func DeletePost(w http.ResponseWriter, r *http.Request) {
 
 	postAuthor, err := db.Query.GetPostAuthor(r.Context(), int32(postId))
 
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Post not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
 	if postAuthor != author {
 		errReq := RequestError{
 			RequestId: requestId,
@@ -387,7 +643,21 @@ func DeletePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.Query.DeletePost(r.Context(), int32(postId))
+	deleted, err := db.Query.DeletePost(r.Context(), sqlc.DeletePostParams{
+		ID:        int32(postId),
+		DeletedBy: pgtype.UUID{Bytes: author, Valid: true},
+	})
+
+	if err == nil && deleted == 0 {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Post not found or already deleted"),
+			cause:     errors.New("Not found"),
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
 
 	if err != nil {
 		errReq := RequestError{
@@ -497,8 +767,8 @@ func UnlikePost(w http.ResponseWriter, r *http.Request) {
 
 func GetComments(w http.ResponseWriter, r *http.Request) {
 	type GetCommentsResp struct {
-		NextOffset *int                  `json:"nextOffset"`
-		Comments   []sqlc.GetCommentsRow `json:"comments"`
+		NextOffset *int           `json:"nextOffset"`
+		Comments   []commentsView `json:"comments"`
 	}
 
 	requestId := r.Context().Value("requestId").(string)
@@ -524,6 +794,17 @@ func GetComments(w http.ResponseWriter, r *http.Request) {
 	offsetStr := r.URL.Query().Get("offset")
 	search := r.URL.Query().Get("search")
 
+	if anonymousIds && strings.HasPrefix(search, "@") {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Search by author is disabled"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
 	var offset int32
 
 	if offsetStr != "" {
@@ -591,11 +872,11 @@ func GetComments(w http.ResponseWriter, r *http.Request) {
 
 	resp := GetCommentsResp{
 		NextOffset: nextOffset,
-		Comments:   comments,
+		Comments:   make([]commentsView, 0, len(comments)),
 	}
 
-	if resp.Comments == nil {
-		resp.Comments = make([]sqlc.GetCommentsRow, 0)
+	for _, comment := range comments {
+		resp.Comments = append(resp.Comments, newCommentsView(comment, int32(postId), author))
 	}
 
 	marshResp, err := json.Marshal(resp)
@@ -651,7 +932,7 @@ func GetComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	marshResp, err := json.Marshal(comment)
+	marshResp, err := json.Marshal(newCommentView(comment, author))
 
 	if err != nil {
 		errReq := RequestError{
@@ -672,17 +953,27 @@ func CreateComment(w http.ResponseWriter, r *http.Request) {
 	type CreateCommentReq struct {
 		Content string `json:"content"`
 		Reply   int32
+		Name    string `json:"name"` // Same as in the CreatePostReq
 	}
 	// Same reason as in the CreatePostResp
 	type CreateCommentResp struct {
-		ID         int32              `json:"id"`
-		Post       int32              `json:"post"`
-		Author     uuid.UUID          `json:"author"`
-		Reply      pgtype.Int4        `json:"reply"`
-		Content    string             `json:"content"`
-		CreatedAt  pgtype.Timestamptz `json:"createdAt"`
-		LikesCount int                `json:"likesCount"`
-		IsLiked    bool               `json:"isLiked"`
+		ID          int32              `json:"id"`
+		Post        int32              `json:"post"`
+		Author      *uuid.UUID         `json:"author,omitempty"`
+		PosterId    string             `json:"posterId,omitempty"`
+		Reply       pgtype.Int4        `json:"reply"`
+		Content     string             `json:"content"`
+		ContentHtml string             `json:"contentHtml"`
+		Quotes      []commentRef       `json:"quotes"`
+		Backlinks   []commentRef       `json:"backlinks"`
+		CreatedAt   pgtype.Timestamptz `json:"createdAt"`
+		Name        pgtype.Text        `json:"name"`
+		Tripcode    pgtype.Text        `json:"tripcode"`
+		LikesCount  int                `json:"likesCount"`
+		IsLiked     bool               `json:"isLiked"`
+		IsMine      bool               `json:"isMine"`
+		IsHeld      bool               `json:"isHeld"`
+		Attachment  *sqlc.Attachment   `json:"attachment"`
 	}
 
 	requestId := r.Context().Value("requestId").(string)
@@ -705,63 +996,215 @@ func CreateComment(w http.ResponseWriter, r *http.Request) {
 
 	var comment CreateCommentReq
 
-	err = json.NewDecoder(r.Body).Decode(&comment)
+	upload, err := decodeBody(r, &comment)
 
 	if err != nil {
+		failBody(w, requestId, err)
+		return
+	}
+
+	if len(comment.Content) == 0 && upload == nil {
 		errReq := RequestError{
 			RequestId: requestId,
-			error:     errors.New("Invalid body"),
+			error:     errors.New("'content' is empty"),
 			cause:     err,
 			Code:      400,
 		}
 		fail(w, errReq)
 		return
+	} else if len(comment.Content) > maxSymbolsForPost {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New(fmt.Sprintf("'content' max length is %d", maxSymbolsForComment)),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+        return
+    }
+
+
+	filtered, err := filter.apply(requestId, strings.TrimSpace(comment.Content))
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     err,
+			cause:     errors.New("Rejected by content filter"),
+			Code:      422,
+		}
+		fail(w, errReq)
+		return
 	}
 
-	if len(comment.Content) == 0 {
+	// A replace rule can leave nothing of the content
+	if filtered.Content == "" && upload == nil {
 		errReq := RequestError{
 			RequestId: requestId,
 			error:     errors.New("'content' is empty"),
-			cause:     err,
+			cause:     errors.New("Bad request"),
 			Code:      400,
 		}
 		fail(w, errReq)
 		return
-	} else if len(comment.Content) > maxSymbolsForPost {
+	}
+
+	fingerprint, err := checkDuplicate(r.Context(), requestId, &filtered, 0, 0)
+
+	if errors.Is(err, errNearDuplicate) {
 		errReq := RequestError{
 			RequestId: requestId,
-			error:     errors.New(fmt.Sprintf("'content' max length is %d", maxSymbolsForComment)),
+			error:     err,
+			cause:     errors.New("Near-duplicate content"),
+			Code:      409,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	name, tripcode, err := parseName(comment.Name)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     err,
 			cause:     errors.New("Bad request"),
 			Code:      400,
 		}
 		fail(w, errReq)
-        return
-    }
+		return
+	}
+
+	thread, err := db.Query.GetPostById(r.Context(), int32(postId))
+
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && thread.DeletedAt.Valid) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Post not found"),
+			cause:     errors.New("Not found"),
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if thread.LockedAt.Valid || thread.ArchivedAt.Valid {
+		reason := "Thread is locked, new comments are not accepted"
+
+		if thread.ArchivedAt.Valid {
+			reason = "Thread is archived, new comments are not accepted"
+		}
+
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New(reason),
+			cause:     errors.New("Forbidden"),
+			Code:      403,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	refPosts, quotes, ok := resolveRefs(w, r, filtered.Content, 0)
+
+	if !ok {
+		return
+	}
+
+	var attachment *sqlc.Attachment
+
+	if upload != nil {
+		saved, err := saveAttachment(r.Context(), upload)
+
+		if err != nil {
+			failAttachment(w, requestId, err)
+			return
+		}
+
+		attachment = &saved
+	}
 
 	uuid := r.Context().Value("author").(uuid.UUID)
 
 	var createdComment sqlc.Comment
 
-	if comment.Reply == 0 {
-		params := sqlc.CreateCommentParams{
-			Post:    int32(postId),
-			Author:  uuid,
-            Content: strings.TrimSpace(comment.Content),
+	// Comment and its references are written together
+	err = inTx(r.Context(), func(qtx *sqlc.Queries) error {
+		var err error
+
+		if comment.Reply == 0 {
+			params := sqlc.CreateCommentParams{
+				Post:        int32(postId),
+				Author:      uuid,
+				Content:     filtered.Content,
+				Name:        name,
+				Tripcode:    tripcode,
+				Visibility:  filtered.Visibility,
+				Fingerprint: fingerprint,
+				ContentHtml: markup.Render(filtered.Content, refPosts),
+				Attachment:  attachmentHash(attachment),
+			}
+
+			createdComment, err = qtx.CreateComment(r.Context(), params)
+		} else {
+			params := sqlc.CreateCommentWithReplyParams{
+				Post:    int32(postId),
+				Author:  uuid,
+				Content: filtered.Content,
+				Reply: pgtype.Int4{
+					Int32: comment.Reply,
+					Valid: true,
+				},
+				Name:        name,
+				Tripcode:    tripcode,
+				Visibility:  filtered.Visibility,
+				Fingerprint: fingerprint,
+				ContentHtml: markup.Render(filtered.Content, refPosts),
+				Attachment:  attachmentHash(attachment),
+			}
+
+			createdComment, err = qtx.CreateCommentWithReply(r.Context(), params)
 		}
 
-		createdComment, err = db.Query.CreateComment(r.Context(), params)
-	} else {
-		params := sqlc.CreateCommentWithReplyParams{
-			Post:    int32(postId),
-			Author:  uuid,
-			Content: comment.Content,
-			Reply: pgtype.Int4{
-				Int32: comment.Reply,
-				Valid: true,
-			},
+		if err != nil {
+			return err
 		}
 
-		createdComment, err = db.Query.CreateCommentWithReply(r.Context(), params)
+		return saveRefs(r.Context(), qtx, createdComment.ID, quotes)
+	})
+
+	// Thread was deleted, locked or archived since it was checked
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Post not found or closed for comments"),
+			cause:     err,
+			Code:      409,
+		}
+		fail(w, errReq)
+		return
 	}
 
 	if err != nil {
@@ -776,16 +1219,25 @@ func CreateComment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	filled := CreateCommentResp{
-		ID:         createdComment.ID,
-		Post:       createdComment.Post,
-		Author:     createdComment.Author,
-		Reply:      createdComment.Reply,
-		Content:    createdComment.Content,
-		CreatedAt:  createdComment.CreatedAt,
-		LikesCount: 0,
-		IsLiked:    false,
+		ID:          createdComment.ID,
+		Post:        createdComment.Post,
+		Reply:       createdComment.Reply,
+		Content:     createdComment.Content,
+		ContentHtml: createdComment.ContentHtml,
+		Quotes:      quotes,
+		Backlinks:   make([]commentRef, 0),
+		CreatedAt:   createdComment.CreatedAt,
+		Name:        createdComment.Name,
+		Tripcode:    createdComment.Tripcode,
+		LikesCount:  0,
+		IsLiked:     false,
+		IsMine:      true,
+		IsHeld:      createdComment.Visibility == visibilityHeld,
+		Attachment:  attachment,
 	}
 
+	filled.Author, filled.PosterId = authorIdentity(createdComment.Author, createdComment.Post)
+
 	marshResp, err := json.Marshal(filled)
 
 	if err != nil {
@@ -914,7 +1366,18 @@ func DeleteComment(w http.ResponseWriter, r *http.Request) {
 
 	commentAuthor, err := db.Query.GetCommentAuthor(r.Context(), int32(commentId))
 
-	if commentAuthor != author {
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Comment not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
 		errReq := RequestError{
 			RequestId: requestId,
 			error:     errors.New("Internal server error"),
@@ -925,7 +1388,32 @@ func DeleteComment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = db.Query.DeleteComment(r.Context(), int32(commentId))
+	if commentAuthor != author {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Forbidden"),
+			cause:     errors.New("Forbidden"),
+			Code:      403,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	deleted, err := db.Query.DeleteComment(r.Context(), sqlc.DeleteCommentParams{
+		ID:        int32(commentId),
+		DeletedBy: pgtype.UUID{Bytes: author, Valid: true},
+	})
+
+	if err == nil && deleted == 0 {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Comment not found or already deleted"),
+			cause:     errors.New("Not found"),
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
 
 	if err != nil {
 		errReq := RequestError{
@@ -940,7 +1428,7 @@ func DeleteComment(w http.ResponseWriter, r *http.Request) {
 
 	code := 204
 	w.WriteHeader(code)
-	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", 200))
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
 }
 
 func NotFound(w http.ResponseWriter, r *http.Request) {
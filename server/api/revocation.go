@@ -0,0 +1,328 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gofrs/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Cache of revocations stored in Postgres. Revocations made by this process are
+// added right away, the ones made by other replicas show up after the next sync.
+type revocationStore struct {
+	mu       sync.RWMutex
+	tokens   map[uuid.UUID]time.Time // jti -> token expiration
+	authors  map[uuid.UUID]time.Time // author -> tokens issued before are revoked
+	families map[uuid.UUID]time.Time // refresh token family -> expiration of its last access token
+}
+
+var revocations = revocationStore{
+	tokens:   map[uuid.UUID]time.Time{},
+	authors:  map[uuid.UUID]time.Time{},
+	families: map[uuid.UUID]time.Time{},
+}
+
+var revocationSyncInterval = envDuration("REVOCATION_SYNC_INTERVAL", 30*time.Second)
+
+func LoadRevocations() {
+	if err := revocations.sync(context.Background()); err != nil {
+		panic(err)
+	}
+
+	go func() {
+		for range time.Tick(revocationSyncInterval) {
+			if err := revocations.sync(context.Background()); err != nil {
+				log.Println(fmt.Sprintf("Revocations sync failed: %s", err))
+			}
+		}
+	}()
+}
+
+func (s *revocationStore) sync(ctx context.Context) error {
+	err := db.Query.DeleteExpiredRevokedTokens(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	err = db.Query.DeleteExpiredRevokedFamilies(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	revokedTokens, err := db.Query.GetRevokedTokens(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	revokedAuthors, err := db.Query.GetRevokedAuthors(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	revokedFamilies, err := db.Query.GetRevokedFamilies(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	tokens := make(map[uuid.UUID]time.Time, len(revokedTokens))
+
+	for _, t := range revokedTokens {
+		tokens[t.Jti] = t.ExpiresAt.Time
+	}
+
+	authors := make(map[uuid.UUID]time.Time, len(revokedAuthors))
+
+	for _, a := range revokedAuthors {
+		authors[a.Author] = a.RevokedAt.Time
+	}
+
+	families := make(map[uuid.UUID]time.Time, len(revokedFamilies))
+
+	for _, f := range revokedFamilies {
+		families[f.Family] = f.ExpiresAt.Time
+	}
+
+	s.mu.Lock()
+	s.tokens = tokens
+	s.authors = authors
+	s.families = families
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *revocationStore) isRevoked(jti uuid.UUID, author uuid.UUID, family uuid.UUID, issuedAt time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.tokens[jti]; ok {
+		return true
+	}
+
+	if _, ok := s.families[family]; ok {
+		return true
+	}
+
+	revokedAt, ok := s.authors[author]
+
+	// iat has a second precision, so a token refreshed right after the revocation
+	// (after a role change for example) has to stay valid in the same second
+	return ok && issuedAt.Before(revokedAt.Truncate(time.Second))
+}
+
+func (s *revocationStore) revokeToken(ctx context.Context, jti uuid.UUID, author uuid.UUID, expiresAt time.Time) error {
+	err := db.Query.RevokeToken(ctx, sqlc.RevokeTokenParams{
+		Jti:    jti,
+		Author: author,
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  expiresAt,
+			Valid: true,
+		},
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.tokens[jti] = expiresAt
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Revokes access tokens only, so the author can get a new one with the refresh token
+func (s *revocationStore) revokeAccessTokens(ctx context.Context, author uuid.UUID) error {
+	revoked, err := db.Query.RevokeAuthorTokens(ctx, author)
+
+	if err != nil {
+		return err
+	}
+
+	s.addAuthor(author, revoked.RevokedAt.Time)
+
+	return nil
+}
+
+// Caches a revocation stored in a transaction of the caller, once it is committed
+func (s *revocationStore) addAuthor(author uuid.UUID, revokedAt time.Time) {
+	s.mu.Lock()
+	s.authors[author] = revokedAt
+	s.mu.Unlock()
+}
+
+// Revokes the refresh tokens of the family and the access tokens issued with them
+func (s *revocationStore) revokeFamily(ctx context.Context, family uuid.UUID) error {
+	expiresAt := time.Now().Add(accessTokenTTL)
+
+	err := inTx(ctx, func(qtx *sqlc.Queries) error {
+		err := qtx.RevokeRefreshTokenFamily(ctx, family)
+
+		if err != nil {
+			return err
+		}
+
+		return qtx.RevokeFamily(ctx, sqlc.RevokeFamilyParams{
+			Family: family,
+			ExpiresAt: pgtype.Timestamptz{
+				Time:  expiresAt,
+				Valid: true,
+			},
+		})
+	})
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.families[family] = expiresAt
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *revocationStore) revokeAuthor(ctx context.Context, author uuid.UUID) error {
+	err := s.revokeAccessTokens(ctx, author)
+
+	if err != nil {
+		return err
+	}
+
+	return db.Query.RevokeAuthorRefreshTokens(ctx, author)
+}
+
+func Logout(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched Logout route"))
+
+	author := r.Context().Value("author").(uuid.UUID)
+	jti := r.Context().Value("jti").(uuid.UUID)
+	family := r.Context().Value("family").(uuid.UUID)
+	tokenExp := r.Context().Value("tokenExp").(time.Time)
+
+	err := revocations.revokeToken(r.Context(), jti, author, tokenExp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	err = db.Query.RevokeRefreshTokenFamily(r.Context(), family)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
+
+func RevokeAuthorTokens(w http.ResponseWriter, r *http.Request) {
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched RevokeAuthorTokens route"))
+
+	authorIdStr := chi.URLParam(r, "authorId")
+
+	author, err := uuid.FromString(authorIdStr)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'authorId' is not a uuid"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	_, err = db.Query.GetAuthor(r.Context(), author)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Author not found"),
+			cause:     err,
+			Code:      404,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	err = revocations.revokeAuthor(r.Context(), author)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	// Revocation also lives in memory, so it can not share a transaction with the log
+	err = logModAction(r.Context(), db.Query, r.Context().Value("author").(uuid.UUID), modAction{
+		Action:     modActionRevokeTokens,
+		TargetType: targetTypeAuthor,
+		TargetId:   author.String(),
+		Reason:     modReason(r),
+	})
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	code := 204
+	w.WriteHeader(code)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", code))
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+func TestRevocationStoreIsRevoked(t *testing.T) {
+	author := uuid.Must(uuid.NewV4())
+	revokedAt := time.Date(2024, 1, 1, 12, 0, 0, 700*int(time.Millisecond), time.UTC)
+
+	store := revocationStore{
+		tokens:   map[uuid.UUID]time.Time{},
+		authors:  map[uuid.UUID]time.Time{author: revokedAt},
+		families: map[uuid.UUID]time.Time{},
+	}
+
+	tests := []struct {
+		name     string
+		issuedAt time.Time
+		want     bool
+	}{
+		{"second before", time.Date(2024, 1, 1, 11, 59, 59, 0, time.UTC), true},
+		{"same second", time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), false},
+		{"second after", time.Date(2024, 1, 1, 12, 0, 1, 0, time.UTC), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := store.isRevoked(uuid.Must(uuid.NewV4()), author, uuid.Must(uuid.NewV4()), test.issuedAt); got != test.want {
+				t.Errorf("isRevoked(iat %s) = %t, want %t", test.issuedAt, got, test.want)
+			}
+		})
+	}
+
+	jti := uuid.Must(uuid.NewV4())
+	store.tokens[jti] = revokedAt
+
+	if !store.isRevoked(jti, uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4()), revokedAt) {
+		t.Error("token revoked by jti is valid")
+	}
+
+	family := uuid.Must(uuid.NewV4())
+	store.families[family] = revokedAt
+
+	if !store.isRevoked(uuid.Must(uuid.NewV4()), uuid.Must(uuid.NewV4()), family, revokedAt) {
+		t.Error("token of a revoked family is valid")
+	}
+}
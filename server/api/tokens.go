@@ -0,0 +1,231 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"snakesss/db"
+	"snakesss/sqlc"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+var accessTokenTTL = envDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+var refreshTokenTTL = envDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+
+type AuthResp struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"` // Access token lifetime in seconds
+	Uuid         string `json:"uuid"`
+}
+
+func hashRefreshToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+
+	return sum[:]
+}
+
+// Signs an access token and stores a new refresh token of the given family,
+// every refresh token obtained by rotating the first one shares its family
+func issueTokens(ctx context.Context, q *sqlc.Queries, author sqlc.Author, family uuid.UUID) (AuthResp, error) {
+	jti, err := uuid.NewV4()
+
+	if err != nil {
+		return AuthResp{}, err
+	}
+
+	now := time.Now()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"jti":  jti,
+		"iat":  now.Unix(),
+		"exp":  now.Add(accessTokenTTL).Unix(),
+		"uuid": author.ID,
+		"ip":   author.Ip,
+		"role": author.Role,
+		"fam":  family, // Refresh token family, revoked on logout
+	})
+
+	token.Header["kid"] = jwtKeys.signingKid
+
+	sign, err := token.SignedString(jwtKeys.signingKey)
+
+	if err != nil {
+		return AuthResp{}, err
+	}
+
+	random := make([]byte, 32)
+
+	_, err = rand.Read(random)
+
+	if err != nil {
+		return AuthResp{}, err
+	}
+
+	refreshToken := base64.RawURLEncoding.EncodeToString(random)
+
+	_, err = q.CreateRefreshToken(ctx, sqlc.CreateRefreshTokenParams{
+		Family:    family,
+		Author:    author.ID,
+		TokenHash: hashRefreshToken(refreshToken),
+		ExpiresAt: pgtype.Timestamptz{
+			Time:  time.Now().Add(refreshTokenTTL),
+			Valid: true,
+		},
+	})
+
+	if err != nil {
+		return AuthResp{}, err
+	}
+
+	return AuthResp{
+		Token:        sign,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		Uuid:         author.ID.String(),
+	}, nil
+}
+
+func RefreshAuth(w http.ResponseWriter, r *http.Request) {
+	type RefreshReq struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+
+	requestId := r.Context().Value("requestId").(string)
+	requestLog(requestId, fmt.Sprintf("Request matched RefreshAuth route"))
+
+	var req RefreshReq
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Body is invalid"),
+			cause:     err,
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if req.RefreshToken == "" {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("'refreshToken' is empty"),
+			cause:     errors.New("Bad request"),
+			Code:      400,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	hash := hashRefreshToken(req.RefreshToken)
+
+	var resp AuthResp
+	var ban *sqlc.Ban
+
+	// Old token is only used up when the new one is stored
+	err = inTx(r.Context(), func(qtx *sqlc.Queries) error {
+		used, err := qtx.UseRefreshToken(r.Context(), hash)
+
+		if err != nil {
+			return err
+		}
+
+		author, err := qtx.GetAuthor(r.Context(), used.Author)
+
+		if err != nil {
+			return err
+		}
+
+		ban, err = activeBan(r.Context(), author.ID, r.Context().Value("clientIp").(netip.Addr))
+
+		if err != nil {
+			return err
+		}
+
+		if ban != nil {
+			return errBanned
+		}
+
+		resp, err = issueTokens(r.Context(), qtx, author, used.Family)
+
+		return err
+	})
+
+	if errors.Is(err, errBanned) {
+		failBanned(w, requestId, ban)
+		return
+	}
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		stored, err := db.Query.GetRefreshToken(r.Context(), hash)
+
+		if err == nil && stored.UsedAt.Valid && !stored.RevokedAt.Valid {
+			// Token was already rotated, so either it leaked or the client is
+			// replaying it, in both cases nobody from this family can be trusted
+			requestLog(requestId, fmt.Sprintf("Refresh token reuse detected, revoking family %s", stored.Family))
+
+			err = revocations.revokeFamily(r.Context(), stored.Family)
+
+			if err != nil {
+				errReq := RequestError{
+					RequestId: requestId,
+					error:     errors.New("Internal server error"),
+					cause:     err,
+					Code:      500,
+				}
+				fail(w, errReq)
+				return
+			}
+		}
+
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Invalid refresh token"),
+			cause:     errors.New("Refresh token is unknown, expired, used or revoked"),
+			Code:      401,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	marshResp, err := json.Marshal(resp)
+
+	if err != nil {
+		errReq := RequestError{
+			RequestId: requestId,
+			error:     errors.New("Internal server error"),
+			cause:     err,
+			Code:      500,
+		}
+		fail(w, errReq)
+		return
+	}
+
+	w.Write(marshResp)
+	requestLog(requestId, fmt.Sprintf("Request successful, code: %d", 200))
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+const maxSymbolsForName = 32
+const tripcodeLength = 10
+
+// Splits 'name#secret' into the display name and a tripcode derived from the
+// secret with TRIPCODE_SALT, so the same secret always gives the same tripcode
+// but it can not be computed without knowing the salt
+func parseName(field string) (pgtype.Text, pgtype.Text, error) {
+	var name, tripcode pgtype.Text
+
+	field = strings.TrimSpace(field)
+
+	if field == "" {
+		return name, tripcode, nil
+	}
+
+	displayName, secret, hasSecret := strings.Cut(field, "#")
+	displayName = strings.TrimSpace(displayName)
+
+	if utf8.RuneCountInString(displayName) > maxSymbolsForName {
+		return name, tripcode, errors.New(fmt.Sprintf("'name' max length is %d", maxSymbolsForName))
+	}
+
+	if displayName != "" {
+		name = pgtype.Text{String: displayName, Valid: true}
+	}
+
+	if !hasSecret || secret == "" {
+		return name, tripcode, nil
+	}
+
+	salt := os.Getenv("TRIPCODE_SALT")
+
+	if salt == "" {
+		return name, tripcode, errors.New("Tripcodes are not enabled")
+	}
+
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(secret))
+
+	code := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:tripcodeLength]
+	tripcode = pgtype.Text{String: code, Valid: true}
+
+	return name, tripcode, nil
+}
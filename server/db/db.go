@@ -8,6 +8,7 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+var Pool *pgxpool.Pool
 var Query *sqlc.Queries
 
 func ConnectDB() {
@@ -19,5 +20,6 @@ func ConnectDB() {
         panic(err)
     }   
 
+    Pool = dbpool
     Query = sqlc.New(dbpool)
 }
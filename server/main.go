@@ -22,31 +22,92 @@ func main() {
     })
 
 	db.ConnectDB()
+	api.LoadKeys()
+	api.LoadRevocations()
+	api.StartPurge()
+	api.StartArchive()
+	api.LoadFilter()
 
+	r.Use(api.ClientIpMiddleware)
     r.Use(api.LoggerMiddleware)
     r.Use(api.RequestSizeMiddleware)
+	r.Use(api.RateLimitMiddleware)
 	r.Use(api.MainMiddleware)
 
-    r.Post("/auth", api.Auth)
+	// Shared between routes, so every way to log in or to post counts towards the same limit
+	authLimit := api.RateLimit("auth", "5/1m")
+	postLimit := api.Cooldown("post", "1/30s")
+
+	r.Get("/challenge", api.PowChallenge)
+	r.With(authLimit, api.PowMiddleware).Post("/auth", api.Auth)
+	r.Post("/auth/refresh", api.RefreshAuth)
+	r.Post("/auth/device/challenge", api.DeviceChallenge)
+	r.With(authLimit, api.PowMiddleware).Post("/auth/device", api.DeviceAuth)
+	r.Get("/.well-known/jwks.json", api.JWKS)
+	r.Get("/files/{key}", api.GetFile)
 
 	r.Route("/", func(r chi.Router) {
 		r.Use(api.AuthMiddleware)
+		r.Use(api.AuthorRateLimitMiddleware)
+		r.Post("/auth/logout", api.Logout)
+		r.Get("/archive", api.GetArchivedPosts)
+		r.Get("/boards", api.GetBoards)
+		r.Route("/boards/{slug}", func(r chi.Router) {
+			r.Get("/posts", api.GetBoardPosts)
+			r.With(postLimit, api.PowMiddleware, api.UploadSizeMiddleware).Post("/posts", api.CreateBoardPost)
+			r.Get("/archive", api.GetBoardArchivedPosts)
+		})
 		r.Route("/posts", func(r chi.Router) {
 			r.Get("/", api.GetPosts)
-			r.Post("/", api.CreatePost)
+			r.With(postLimit, api.PowMiddleware, api.UploadSizeMiddleware).Post("/", api.CreatePost)
 			r.Route("/{postId}", func(r chi.Router) {
+				r.Patch("/", api.EditPost)
 				r.Delete("/", api.DeletePost)
+				r.Get("/revisions", api.GetPostRevisions)
 				r.Post("/like", api.LikePost)
 				r.Delete("/like", api.UnlikePost)
+				r.Post("/report", api.ReportPost)
+				r.Post("/poll/vote", api.VotePoll)
 				r.Get("/comments", api.GetComments)
-				r.Post("/comments", api.CreateComment)
+				r.With(api.Cooldown("comment", "1/5s"), api.PowMiddleware, api.UploadSizeMiddleware).Post("/comments", api.CreateComment)
 			})
 		})
 		r.Route("/comments/{commentId}", func(r chi.Router) {
             r.Get("/", api.GetComment)
+			r.Patch("/", api.EditComment)
 			r.Delete("/", api.DeleteComment)
+			r.Get("/revisions", api.GetCommentRevisions)
 			r.Post("/like", api.LikeComment)
 			r.Delete("/like", api.UnlikeComment)
+			r.Post("/report", api.ReportComment)
+		})
+		r.Route("/mod", func(r chi.Router) {
+			r.Use(api.RequireRole(api.RoleModerator, api.RoleAdmin))
+			r.Delete("/posts/{postId}", api.ModDeletePost)
+			r.Post("/posts/{postId}/restore", api.RestorePost)
+			r.Post("/posts/{postId}/lock", api.LockPost)
+			r.Delete("/posts/{postId}/lock", api.UnlockPost)
+			r.Put("/posts/{postId}/pin", api.PinPost)
+			r.Delete("/posts/{postId}/pin", api.UnpinPost)
+			r.Delete("/comments/{commentId}", api.ModDeleteComment)
+			r.Post("/comments/{commentId}/restore", api.RestoreComment)
+			r.Get("/held", api.GetHeld)
+			r.Put("/authors/{authorId}/shadowban", api.SetAuthorShadowban)
+			r.Post("/posts/{postId}/approve", api.ApprovePost)
+			r.Post("/comments/{commentId}/approve", api.ApproveComment)
+			r.Post("/bans", api.CreateBan)
+			r.Get("/bans", api.GetBans)
+			r.Delete("/bans/{banId}", api.LiftBan)
+			r.Get("/reports", api.GetReports)
+			r.Post("/reports/posts/{postId}/resolve", api.ResolvePostReports)
+			r.Post("/reports/comments/{commentId}/resolve", api.ResolveCommentReports)
+			r.Group(func(r chi.Router) {
+				r.Use(api.RequireRole(api.RoleAdmin))
+				r.Put("/authors/{authorId}/role", api.SetAuthorRole)
+				r.Post("/authors/{authorId}/revoke", api.RevokeAuthorTokens)
+				r.Get("/actions", api.GetModActions)
+				r.Put("/boards/{slug}", api.SetBoard)
+			})
 		})
 	})
 
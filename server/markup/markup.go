@@ -0,0 +1,286 @@
+package markup
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Markup of posts and comments:
+//   - '>text' at the start of a line is greentext
+//   - lines between two '```' lines are a code block, shown as is
+//   - `code`, **bold**, *italic* and %%spoiler%% inside a line
+//   - http(s) urls become links
+//   - '>>123' links to the comment 123, when it is one of the known refs
+//
+// Everything else is escaped, so the only tags in the output are the ones made
+// here and it can be inserted into a page as is.
+
+const (
+	tokenText = iota
+	tokenDelim
+	tokenCode
+	tokenLink
+	tokenRef
+)
+
+type token struct {
+	kind  int
+	value string
+	pair  int // Index of the matching delimiter, -1 when it has none
+}
+
+var linkRegexp = regexp.MustCompile(`^https?://[^\s<>"']+`)
+
+// '>>123' is a reference to another comment, not greentext
+var quoteRefRegexp = regexp.MustCompile(`^>>\d`)
+
+var refRegexp = regexp.MustCompile(`^>>(\d+)`)
+
+var delimTags = map[string][2]string{
+	"**": {"<strong>", "</strong>"},
+	"*":  {"<em>", "</em>"},
+	"%%": {`<span class="spoiler">`, "</span>"},
+}
+
+// Refs maps ids of the referenced comments to their posts, other references stay text
+func Render(src string, refs map[int32]int32) string {
+	var builder strings.Builder
+
+	lines := splitLines(src)
+
+	var code []string
+	inCode := false
+	afterText := false
+
+	for _, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			if inCode {
+				writeCode(&builder, code)
+				code = nil
+			}
+
+			inCode = !inCode
+			afterText = false
+			continue
+		}
+
+		if inCode {
+			code = append(code, line)
+			continue
+		}
+
+		if afterText {
+			builder.WriteString("<br>")
+		}
+
+		builder.WriteString(renderLine(line, refs))
+		afterText = true
+	}
+
+	// Unclosed block runs to the end
+	if inCode {
+		writeCode(&builder, code)
+	}
+
+	return builder.String()
+}
+
+// Ids of the comments referenced outside of code, in order and without repeats
+func Refs(src string) []int32 {
+	var refs []int32
+
+	seen := make(map[int32]bool)
+	inCode := false
+
+	for _, line := range splitLines(src) {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+
+		if inCode {
+			continue
+		}
+
+		for _, t := range tokenize(line) {
+			if t.kind != tokenRef {
+				continue
+			}
+
+			id := refId(t.value)
+
+			if !seen[id] {
+				seen[id] = true
+				refs = append(refs, id)
+			}
+		}
+	}
+
+	return refs
+}
+
+func splitLines(src string) []string {
+	return strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+}
+
+// Value of a ref token is always a valid id
+func refId(value string) int32 {
+	id, _ := strconv.ParseInt(strings.TrimPrefix(value, ">>"), 10, 32)
+	return int32(id)
+}
+
+func writeCode(builder *strings.Builder, lines []string) {
+	builder.WriteString("<pre><code>")
+	builder.WriteString(html.EscapeString(strings.Join(lines, "\n")))
+	builder.WriteString("</code></pre>")
+}
+
+func renderLine(line string, refs map[int32]int32) string {
+	if strings.HasPrefix(line, ">") && !quoteRefRegexp.MatchString(line) {
+		return `<span class="greentext">` + renderInline(line, refs) + `</span>`
+	}
+
+	return renderInline(line, refs)
+}
+
+func renderInline(line string, refs map[int32]int32) string {
+	tokens := matchDelims(tokenize(line))
+
+	var builder strings.Builder
+
+	for i, t := range tokens {
+		switch t.kind {
+		case tokenText:
+			builder.WriteString(html.EscapeString(t.value))
+		case tokenCode:
+			builder.WriteString("<code>" + html.EscapeString(t.value) + "</code>")
+		case tokenLink:
+			url := html.EscapeString(t.value)
+			builder.WriteString(`<a href="` + url + `" rel="nofollow noopener noreferrer" target="_blank">` + url + "</a>")
+		case tokenRef:
+			id := refId(t.value)
+
+			if post, ok := refs[id]; ok {
+				builder.WriteString(fmt.Sprintf(`<a class="quote" href="#comment-%d" data-post="%d">%s</a>`, id, post, html.EscapeString(t.value)))
+			} else {
+				builder.WriteString(html.EscapeString(t.value))
+			}
+		case tokenDelim:
+			switch {
+			case t.pair < 0:
+				builder.WriteString(html.EscapeString(t.value))
+			case t.pair > i:
+				builder.WriteString(delimTags[t.value][0])
+			default:
+				builder.WriteString(delimTags[t.value][1])
+			}
+		}
+	}
+
+	return builder.String()
+}
+
+func tokenize(line string) []token {
+	var tokens []token
+	var text strings.Builder
+
+	flush := func() {
+		if text.Len() > 0 {
+			tokens = append(tokens, token{kind: tokenText, value: text.String(), pair: -1})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(line); {
+		rest := line[i:]
+
+		if rest[0] == '`' {
+			if end := strings.IndexByte(rest[1:], '`'); end > 0 {
+				flush()
+				tokens = append(tokens, token{kind: tokenCode, value: rest[1 : end+1], pair: -1})
+				i += end + 2
+				continue
+			}
+		}
+
+		if strings.HasPrefix(rest, "http") {
+			if link := linkRegexp.FindString(rest); link != "" {
+				// Punctuation after a link belongs to the sentence
+				link = strings.TrimRight(link, ".,;:!?)")
+
+				flush()
+				tokens = append(tokens, token{kind: tokenLink, value: link, pair: -1})
+				i += len(link)
+				continue
+			}
+		}
+
+		if strings.HasPrefix(rest, ">>") {
+			if ref := refRegexp.FindStringSubmatch(rest); ref != nil {
+				// Too large to be an id, left as text
+				if _, err := strconv.ParseInt(ref[1], 10, 32); err == nil {
+					flush()
+					tokens = append(tokens, token{kind: tokenRef, value: ref[0], pair: -1})
+					i += len(ref[0])
+					continue
+				}
+			}
+		}
+
+		if strings.HasPrefix(rest, "**") || strings.HasPrefix(rest, "%%") {
+			flush()
+			tokens = append(tokens, token{kind: tokenDelim, value: rest[:2], pair: -1})
+			i += 2
+			continue
+		}
+
+		if rest[0] == '*' {
+			flush()
+			tokens = append(tokens, token{kind: tokenDelim, value: "*", pair: -1})
+			i++
+			continue
+		}
+
+		text.WriteByte(rest[0])
+		i++
+	}
+
+	flush()
+
+	return tokens
+}
+
+// Pairs delimiters like brackets, so tags never overlap. Openers left inside a
+// closed pair and delimiters around nothing stay as text.
+func matchDelims(tokens []token) []token {
+	var stack []int
+
+	for i, t := range tokens {
+		if t.kind != tokenDelim {
+			continue
+		}
+
+		open := -1
+
+		for k := len(stack) - 1; k >= 0; k-- {
+			if tokens[stack[k]].value == t.value {
+				open = k
+				break
+			}
+		}
+
+		if open < 0 || stack[open] == i-1 {
+			stack = append(stack, i)
+			continue
+		}
+
+		tokens[stack[open]].pair = i
+		tokens[i].pair = stack[open]
+		stack = stack[:open]
+	}
+
+	return tokens
+}
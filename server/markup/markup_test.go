@@ -0,0 +1,90 @@
+package markup
+
+import (
+	"slices"
+	"testing"
+)
+
+const linkAttrs = `rel="nofollow noopener noreferrer" target="_blank"`
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		refs map[int32]int32
+		want string
+	}{
+		{"plain", "hello", nil, "hello"},
+		{"escaping", `<b onclick="x">&'</b>`, nil, "&lt;b onclick=&#34;x&#34;&gt;&amp;&#39;&lt;/b&gt;"},
+		{"bold", "**a**", nil, "<strong>a</strong>"},
+		{"italic", "*a*", nil, "<em>a</em>"},
+		{"spoiler", "%%a%%", nil, `<span class="spoiler">a</span>`},
+		{"nested", "**a *b* c**", nil, "<strong>a <em>b</em> c</strong>"},
+		{"overlapping", "**a *b** c*", nil, "<strong>a *b</strong> c*"},
+		{"unclosed", "**a *b", nil, "**a *b"},
+		{"around nothing", "****", nil, "****"},
+		{"code span", "`**a** <b>`", nil, "<code>**a** &lt;b&gt;</code>"},
+		{"empty code span", "``", nil, "``"},
+		{"unclosed code span", "`*a*", nil, "`<em>a</em>"},
+		{
+			"link with ampersand",
+			"see https://example.com/?a=1&b=2.",
+			nil,
+			`see <a href="https://example.com/?a=1&amp;b=2" ` + linkAttrs + `>https://example.com/?a=1&amp;b=2</a>.`,
+		},
+		{
+			"link ends at quote",
+			`https://example.com/"onmouseover="x`,
+			nil,
+			`<a href="https://example.com/" ` + linkAttrs + `>https://example.com/</a>&#34;onmouseover=&#34;x`,
+		},
+		{
+			"delimiters inside link",
+			"https://example.com/*a*",
+			nil,
+			`<a href="https://example.com/*a*" ` + linkAttrs + `>https://example.com/*a*</a>`,
+		},
+		{"greentext", ">be me", nil, `<span class="greentext">&gt;be me</span>`},
+		{"greentext with markup", ">*a*", nil, `<span class="greentext">&gt;<em>a</em></span>`},
+		{"greentext of arrows", ">>> a", nil, `<span class="greentext">&gt;&gt;&gt; a</span>`},
+		{"known ref", ">>12 yes", map[int32]int32{12: 3}, `<a class="quote" href="#comment-12" data-post="3">&gt;&gt;12</a> yes`},
+		{"unknown ref", ">>12 yes", nil, "&gt;&gt;12 yes"},
+		{"ref too large", ">>99999999999", map[int32]int32{12: 3}, "&gt;&gt;99999999999"},
+		{"lines", "a\r\nb", nil, "a<br>b"},
+		{"code block", "a\n```\n*b* <c>\n>>12\n```\nd", map[int32]int32{12: 3}, "a<pre><code>*b* &lt;c&gt;\n&gt;&gt;12</code></pre>d"},
+		{"unclosed code block", "```\n*a*", nil, "<pre><code>*a*</code></pre>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Render(test.src, test.refs)
+
+			if got != test.want {
+				t.Errorf("Render(%q) = %q, want %q", test.src, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRefs(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []int32
+	}{
+		{"none", "hello", nil},
+		{"in order without repeats", ">>3 >>1 >>3", []int32{3, 1}},
+		{"not in code", "```\n>>1\n```\n`>>2` >>3", []int32{3}},
+		{"too large", ">>99999999999 >>4", []int32{4}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Refs(test.src)
+
+			if !slices.Equal(got, test.want) {
+				t.Errorf("Refs(%q) = %v, want %v", test.src, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package media
+
+// Counts the frames of a gif by walking its blocks, without decompressing any
+// of them. Frames are never larger than the canvas, so frames*width*height
+// bounds the pixels DecodeAll would produce.
+func gifFrames(data []byte) (int, bool) {
+	// Header and logical screen descriptor
+	if len(data) < 13 {
+		return 0, false
+	}
+
+	i := 13
+
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+
+	frames := 0
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // Extension: label and sub-blocks
+			if i+2 > len(data) {
+				return 0, false
+			}
+
+			next, ok := skipSubBlocks(data, i+2)
+
+			if !ok {
+				return 0, false
+			}
+
+			i = next
+		case 0x2C: // Image descriptor, local color table, lzw code size and sub-blocks
+			if i+10 > len(data) {
+				return 0, false
+			}
+
+			packed := data[i+9]
+			i += 10
+
+			if packed&0x80 != 0 {
+				i += 3 << (packed&0x07 + 1)
+			}
+
+			next, ok := skipSubBlocks(data, i+1)
+
+			if !ok {
+				return 0, false
+			}
+
+			frames++
+			i = next
+		case 0x3B: // Trailer
+			return frames, true
+		default:
+			return 0, false
+		}
+	}
+
+	// Truncated files are decoded up to where they end
+	return frames, true
+}
+
+// Returns the position after the terminating empty sub-block
+func skipSubBlocks(data []byte, i int) (int, bool) {
+	for i < len(data) {
+		size := int(data[i])
+		i++
+
+		if size == 0 {
+			return i, true
+		}
+
+		i += size
+	}
+
+	return 0, false
+}
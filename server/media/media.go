@@ -0,0 +1,219 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+// Uploaded images are decoded and encoded again, so nothing but the pixels is
+// kept: exif, comments, color profiles and anything appended to the file are
+// dropped. Jpeg orientation from exif is applied to the pixels before that.
+
+var ErrUnsupported = errors.New("Only jpeg, png and gif images are supported")
+var ErrTooLarge = errors.New("Image has too many pixels")
+
+const jpegQuality = 90
+
+type Image struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
+
+	Thumb            []byte
+	ThumbContentType string
+	ThumbWidth       int
+	ThumbHeight      int
+}
+
+// Type is taken from the data, never from what the client says it is
+func Process(data []byte, maxPixels int, thumbSize int) (*Image, error) {
+	contentType := http.DetectContentType(data)
+
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+	default:
+		return nil, ErrUnsupported
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+
+	// Checked before decoding, a small file can decode to gigabytes
+	if config.Width*config.Height > maxPixels || config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrTooLarge
+	}
+
+	result := &Image{ContentType: contentType}
+
+	var first image.Image
+	var out bytes.Buffer
+
+	switch contentType {
+	case "image/jpeg":
+		img, err := jpeg.Decode(bytes.NewReader(data))
+
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+
+		first = orient(img, jpegOrientation(data))
+
+		err = jpeg.Encode(&out, first, &jpeg.Options{Quality: jpegQuality})
+
+		if err != nil {
+			return nil, err
+		}
+	case "image/png":
+		img, err := png.Decode(bytes.NewReader(data))
+
+		if err != nil {
+			return nil, ErrUnsupported
+		}
+
+		first = img
+
+		err = png.Encode(&out, first)
+
+		if err != nil {
+			return nil, err
+		}
+	case "image/gif":
+		frames, ok := gifFrames(data)
+
+		if !ok || frames == 0 {
+			return nil, ErrUnsupported
+		}
+
+		// Pixels of all frames together, counted before any of them is decoded
+		if frames*config.Width*config.Height > maxPixels {
+			return nil, ErrTooLarge
+		}
+
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+
+		if err != nil || len(anim.Image) == 0 {
+			return nil, ErrUnsupported
+		}
+
+		// Frames can be smaller than the image, the first one is drawn on the full canvas
+		canvas := image.NewRGBA(image.Rect(0, 0, config.Width, config.Height))
+		draw.Draw(canvas, anim.Image[0].Bounds(), anim.Image[0], anim.Image[0].Bounds().Min, draw.Src)
+
+		first = canvas
+
+		err = gif.EncodeAll(&out, &gif.GIF{
+			Image:           anim.Image,
+			Delay:           anim.Delay,
+			LoopCount:       anim.LoopCount,
+			Disposal:        anim.Disposal,
+			Config:          anim.Config,
+			BackgroundIndex: anim.BackgroundIndex,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result.Data = out.Bytes()
+	result.Width = first.Bounds().Dx()
+	result.Height = first.Bounds().Dy()
+
+	thumb := scaleDown(first, thumbSize)
+
+	var thumbOut bytes.Buffer
+
+	// Jpeg has no transparency, so other types get png thumbnails
+	if contentType == "image/jpeg" {
+		result.ThumbContentType = "image/jpeg"
+		err = jpeg.Encode(&thumbOut, thumb, &jpeg.Options{Quality: jpegQuality})
+	} else {
+		result.ThumbContentType = "image/png"
+		err = png.Encode(&thumbOut, thumb)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	result.Thumb = thumbOut.Bytes()
+	result.ThumbWidth = thumb.Bounds().Dx()
+	result.ThumbHeight = thumb.Bounds().Dy()
+
+	return result, nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Bounds().Min == (image.Point{}) {
+		return rgba
+	}
+
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+
+	return rgba
+}
+
+// Fits the image into size x size keeping the aspect ratio, every pixel of the
+// thumbnail is the average of the pixels it covers
+func scaleDown(img image.Image, size int) *image.RGBA {
+	src := toRGBA(img)
+
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+
+	if width <= size && height <= size {
+		return src
+	}
+
+	thumbWidth, thumbHeight := size, size
+
+	if width > height {
+		thumbHeight = max(1, height*size/width)
+	} else {
+		thumbWidth = max(1, width*size/height)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, thumbWidth, thumbHeight))
+
+	for y := 0; y < thumbHeight; y++ {
+		y0, y1 := y*height/thumbHeight, max((y+1)*height/thumbHeight, y*height/thumbHeight+1)
+
+		for x := 0; x < thumbWidth; x++ {
+			x0, x1 := x*width/thumbWidth, max((x+1)*width/thumbWidth, x*width/thumbWidth+1)
+
+			var r, g, b, a, n int
+
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					b += int(row[sx*4+2])
+					a += int(row[sx*4+3])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
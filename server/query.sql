@@ -11,14 +11,25 @@ SELECT
     comment.id,
     comment.author,
     comment.content,
+    comment.content_html,
+    attachment_json(attachment) as "attachment",
+    comment_quotes(comment.id) as "quotes",
+    comment_backlinks(comment.id, $2) as "backlinks",
+    comment.name,
+    comment.tripcode,
     reply_comment.id as "reply_comment_id",
     reply_comment.author as "reply_comment_author",
+    reply_comment.deleted_at IS NOT NULL as "reply_comment_deleted",
     comment.created_at,
+    comment.deleted_at,
+    comment.edited_at,
     coalesce(likes.count, 0) as "likes_count",
     CASE WHEN mine_like.id IS NOT NULL THEN true ELSE false END as "is_liked"
 FROM comment
 LEFT JOIN (
     SELECT count(comment_like.comment) as "count", comment_like.comment as "comment_id" FROM comment_like
+    JOIN author ON author.id = comment_like.author
+    WHERE NOT author.shadowbanned OR author.id = $2
     GROUP BY comment_like.comment
 ) as likes
 ON likes.comment_id = comment.id
@@ -29,8 +40,14 @@ LEFT JOIN (
 ON mine_like.id = comment.id
 LEFT JOIN comment as reply_comment 
 ON reply_comment.id = comment.reply
-WHERE comment.post = $1 AND CASE WHEN @search::text != '' THEN 
-    CASE WHEN LEFT(@search::text, 1) = '@' THEN
+JOIN author as comment_author
+ON comment_author.id = comment.author
+LEFT JOIN attachment
+ON attachment.hash = comment.attachment
+WHERE comment.post = $1 
+    AND (comment.author = $2 OR (comment.visibility = 'visible' AND NOT comment_author.shadowbanned))
+    AND CASE WHEN @search::text != '' THEN 
+    comment.deleted_at IS NULL AND CASE WHEN LEFT(@search::text, 1) = '@' THEN
         comment.author::text ILIKE concat('%', SUBSTRING(@search::text, 2), '%') 
     ELSE 
         comment.content ILIKE concat('%', @search::text, '%') 
@@ -45,16 +62,28 @@ LIMIT $3 OFFSET $4;
 -- name: GetComment :one
 SELECT 
     comment.id,
+    comment.post,
     comment.author,
     comment.content,
+    comment.content_html,
+    attachment_json(attachment) as "attachment",
+    comment_quotes(comment.id) as "quotes",
+    comment_backlinks(comment.id, $2) as "backlinks",
+    comment.name,
+    comment.tripcode,
     reply_comment.id as "reply_comment_id",
     reply_comment.author as "reply_comment_author",
+    reply_comment.deleted_at IS NOT NULL as "reply_comment_deleted",
     comment.created_at,
+    comment.deleted_at,
+    comment.edited_at,
     coalesce(likes.count, 0) as "likes_count",
     CASE WHEN mine_like.id IS NOT NULL THEN true ELSE false END as "is_liked"
 FROM comment
 LEFT JOIN (
     SELECT count(comment_like.comment) as "count", comment_like.comment as "comment_id" FROM comment_like
+    JOIN author ON author.id = comment_like.author
+    WHERE NOT author.shadowbanned OR author.id = $2
     GROUP BY comment_like.comment
 ) as likes
 ON likes.comment_id = comment.id
@@ -65,25 +94,46 @@ LEFT JOIN (
 ON mine_like.id = comment.id
 LEFT JOIN comment as reply_comment 
 ON reply_comment.id = comment.reply
-WHERE comment.id = $1;
+JOIN author as comment_author
+ON comment_author.id = comment.author
+LEFT JOIN attachment
+ON attachment.hash = comment.attachment
+WHERE comment.id = $1 
+    AND (comment.author = $2 OR (comment.visibility = 'visible' AND NOT comment_author.shadowbanned));
 
 -- name: GetPosts :many
 SELECT 
     post.id, 
     post.author, 
+    board.slug as "board",
     post.created_at, 
+    post.edited_at,
     post.content, 
+    post.content_html,
+    attachment_json(attachment) as "attachment",
+    poll_json(poll, $1) as "poll",
+    post.name,
+    post.tripcode,
+    post.locked_at,
+    post.archived_at,
+    post.pinned_until,
+    CASE WHEN post.pinned_at IS NOT NULL AND (post.pinned_until IS NULL OR post.pinned_until > now()) THEN true ELSE false END as "is_pinned",
     coalesce(likes.count, 0) as "likes_count",
     coalesce(comments.count, 0) as "comments_count",
     CASE WHEN mine_like.id IS NOT NULL THEN true ELSE false END as "is_liked"
 FROM post  
 LEFT JOIN (
     SELECT count(post_like.post) as "count", post_like.post as "post_id" FROM post_like
+    JOIN author ON author.id = post_like.author
+    WHERE NOT author.shadowbanned OR author.id = $1
     GROUP BY post_like.post
 ) as likes
 ON likes.post_id = post.id
 LEFT JOIN (
     SELECT count(comment.id) as "count", comment.post as "post_id" FROM comment 
+    JOIN author ON author.id = comment.author
+    WHERE comment.deleted_at IS NULL
+        AND (comment.author = $1 OR (comment.visibility = 'visible' AND NOT author.shadowbanned))
     GROUP BY comment.post
 ) as comments
 ON comments.post_id = post.id
@@ -92,14 +142,31 @@ LEFT JOIN (
     WHERE post_like.author = $1
 ) as mine_like 
 ON mine_like.id = post.id
-WHERE CASE WHEN @search::text != '' THEN 
-    CASE WHEN LEFT(@search::text, 1) = '@' THEN
-        post.author::text ILIKE concat('%', SUBSTRING(@search::text, 2), '%') 
-    ELSE 
-        post.content ILIKE concat('%', @search::text, '%') 
-    END
-ELSE true END
+JOIN author as post_author
+ON post_author.id = post.author
+JOIN board
+ON board.id = post.board
+LEFT JOIN attachment
+ON attachment.hash = post.attachment
+LEFT JOIN poll
+ON poll.post = post.id
+WHERE post.deleted_at IS NULL 
+    AND (post.archived_at IS NOT NULL) = @archived::bool
+    AND (sqlc.narg(board)::int IS NULL OR post.board = sqlc.narg(board))
+    AND (post.author = $1 OR (post.visibility = 'visible' AND NOT post_author.shadowbanned))
+    AND (
+        (post.pinned_at IS NOT NULL AND (post.pinned_until IS NULL OR post.pinned_until > now()))
+        OR CASE WHEN @search::text != '' THEN 
+        CASE WHEN LEFT(@search::text, 1) = '@' THEN
+            post.author::text ILIKE concat('%', SUBSTRING(@search::text, 2), '%') 
+        ELSE 
+            post.content ILIKE concat('%', @search::text, '%') 
+        END
+    ELSE true END
+    )
 ORDER BY 
+      "is_pinned" DESC,
+      CASE WHEN post.pinned_at IS NOT NULL AND (post.pinned_until IS NULL OR post.pinned_until > now()) THEN post.pin_order END ASC,
       CASE WHEN @date_asc::bool THEN post.created_at END ASC,
       CASE WHEN @date_desc::bool THEN post.created_at END DESC,
       CASE WHEN @top_asc::bool THEN likes.count END ASC
@@ -110,22 +177,86 @@ SELECT author from post
 WHERE id = $1;
 
 -- name: CreatePost :one
-INSERT INTO post (author, content)
-VALUES ($1, $2)
+INSERT INTO post (author, content, name, tripcode, visibility, fingerprint, board, content_html, attachment)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
 RETURNING *;
 
--- name: DeletePost :exec
-DELETE FROM post
-WHERE id = $1;
+-- name: CreatePoll :one
+INSERT INTO poll (post, question, options, multiple, closes_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetPoll :one
+SELECT poll.*, post.author, post.visibility, post.deleted_at, post.archived_at FROM poll
+JOIN post ON post.id = poll.post
+WHERE poll.post = $1;
+
+-- name: GetPollResults :one
+SELECT poll_json(poll, @viewer::uuid) FROM poll
+WHERE poll.post = @post;
+
+-- name: VotePoll :one
+-- Inserts nothing when the poll is closed, the vote does not fit it or the author already voted
+INSERT INTO poll_vote (poll, author, options)
+SELECT poll.post, @author::uuid, @options::smallint[] FROM poll
+JOIN post ON post.id = poll.post
+WHERE poll.post = @poll
+    AND post.deleted_at IS NULL
+    AND post.archived_at IS NULL
+    AND (poll.closes_at IS NULL OR poll.closes_at > now())
+    AND (poll.multiple OR cardinality(@options::smallint[]) = 1)
+ON CONFLICT DO NOTHING
+RETURNING *;
+
+-- name: CreatePostRevision :one
+-- Locks the post until the transaction ends, so concurrent edits keep every version
+INSERT INTO post_revision (post, content, content_html, created_at)
+SELECT post.id, post.content, post.content_html, coalesce(post.edited_at, post.created_at) FROM post
+WHERE post.id = $1
+FOR UPDATE
+RETURNING *;
+
+-- name: EditPost :one
+-- Held and shadowed posts stay so after an edit
+UPDATE post SET
+    content = @content,
+    content_html = @content_html,
+    fingerprint = @fingerprint,
+    visibility = CASE WHEN post.visibility = 'visible' THEN @visibility::text ELSE post.visibility END,
+    edited_at = now()
+WHERE post.id = @id AND post.author = @author AND post.deleted_at IS NULL AND post.archived_at IS NULL
+    AND post.created_at > @editable_since::timestamptz
+RETURNING *;
+
+-- name: GetPostRevisions :many
+SELECT post_revision.* FROM post_revision
+JOIN post ON post.id = post_revision.post
+JOIN author ON author.id = post.author
+WHERE post_revision.post = $1 AND post.deleted_at IS NULL
+    AND (post.author = $2 OR (post.visibility = 'visible' AND NOT author.shadowbanned))
+ORDER BY post_revision.id DESC;
+
+-- name: DeletePost :execrows
+UPDATE post
+SET deleted_at = now(), deleted_by = $2
+WHERE id = $1 AND deleted_at IS NULL;
 
 -- name: CreateComment :one
-INSERT INTO comment (author, post, content)
-VALUES ($1, $2, $3)
+INSERT INTO comment (author, post, content, name, tripcode, visibility, fingerprint, content_html, attachment)
+SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9
+WHERE EXISTS (
+    SELECT 1 FROM post 
+    WHERE post.id = $2 AND post.deleted_at IS NULL AND post.locked_at IS NULL AND post.archived_at IS NULL
+)
 RETURNING *;
 
 -- name: CreateCommentWithReply :one
-INSERT INTO comment (author, post, content, reply)
-VALUES ($1, $2, $3, $4)
+INSERT INTO comment (author, post, content, reply, name, tripcode, visibility, fingerprint, content_html, attachment)
+SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+WHERE EXISTS (
+    SELECT 1 FROM post 
+    WHERE post.id = $2 AND post.deleted_at IS NULL AND post.locked_at IS NULL AND post.archived_at IS NULL
+)
 RETURNING *;
 
 -- name: GetCommentAuthor :one
@@ -150,6 +281,388 @@ RETURNING *;
 DELETE FROM comment_like
 WHERE comment = $1 AND author = $2;
 
--- name: DeleteComment :exec
+-- name: CreateCommentRevision :one
+-- Locks the comment until the transaction ends, so concurrent edits keep every version
+INSERT INTO comment_revision (comment, content, content_html, created_at)
+SELECT comment.id, comment.content, comment.content_html, coalesce(comment.edited_at, comment.created_at) FROM comment
+WHERE comment.id = $1
+FOR UPDATE
+RETURNING *;
+
+-- name: EditComment :one
+-- Comments in locked or archived threads can't be edited
+UPDATE comment SET
+    content = @content,
+    content_html = @content_html,
+    fingerprint = @fingerprint,
+    visibility = CASE WHEN comment.visibility = 'visible' THEN @visibility::text ELSE comment.visibility END,
+    edited_at = now()
+FROM post
+WHERE comment.id = @id AND comment.author = @author AND comment.deleted_at IS NULL
+    AND comment.created_at > @editable_since::timestamptz
+    AND post.id = comment.post AND post.deleted_at IS NULL AND post.locked_at IS NULL AND post.archived_at IS NULL
+RETURNING comment.*;
+
+-- name: GetCommentRevisions :many
+SELECT comment_revision.* FROM comment_revision
+JOIN comment ON comment.id = comment_revision.comment
+JOIN author ON author.id = comment.author
+WHERE comment_revision.comment = $1 AND comment.deleted_at IS NULL
+    AND (comment.author = $2 OR (comment.visibility = 'visible' AND NOT author.shadowbanned))
+ORDER BY comment_revision.id DESC;
+
+-- name: GetRefTargets :many
+-- Referenced comments that exist and the author can see, a comment can't reference itself
+SELECT comment.id, comment.post FROM comment
+JOIN post ON post.id = comment.post
+JOIN author ON author.id = comment.author
+WHERE comment.id = ANY (@ids::int[]) AND comment.id != @self::int
+    AND comment.deleted_at IS NULL AND post.deleted_at IS NULL
+    AND (comment.author = @author OR (comment.visibility = 'visible' AND NOT author.shadowbanned));
+
+-- name: CreateCommentRefs :exec
+INSERT INTO comment_ref (comment, target)
+SELECT @comment::int, unnest(@targets::int[])
+ON CONFLICT DO NOTHING;
+
+-- name: DeleteCommentRefs :exec
+DELETE FROM comment_ref
+WHERE comment = $1;
+
+-- name: DeleteComment :execrows
+UPDATE comment
+SET deleted_at = now(), deleted_by = $2
+WHERE id = $1 AND deleted_at IS NULL;
+
+-- name: GetAuthor :one
+SELECT * FROM author
+WHERE id = $1;
+
+-- name: CreateRefreshToken :one
+INSERT INTO refresh_token (family, author, token_hash, expires_at)
+VALUES ($1, $2, $3, $4)
+RETURNING *;
+
+-- name: UseRefreshToken :one
+UPDATE refresh_token
+SET used_at = now()
+WHERE token_hash = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+RETURNING *;
+
+-- name: GetRefreshToken :one
+SELECT * FROM refresh_token
+WHERE token_hash = $1;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_token
+SET revoked_at = now()
+WHERE family = $1 AND revoked_at IS NULL;
+
+-- name: RevokeAuthorRefreshTokens :exec
+UPDATE refresh_token
+SET revoked_at = now()
+WHERE author = $1 AND revoked_at IS NULL;
+
+-- name: RevokeToken :exec
+INSERT INTO revoked_token (jti, author, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (jti) DO NOTHING;
+
+-- name: GetRevokedTokens :many
+SELECT jti, expires_at FROM revoked_token
+WHERE expires_at > now();
+
+-- name: DeleteExpiredRevokedTokens :exec
+DELETE FROM revoked_token
+WHERE expires_at <= now();
+
+-- name: RevokeAuthorTokens :one
+INSERT INTO revoked_author (author)
+VALUES ($1)
+ON CONFLICT (author)
+DO UPDATE SET
+    revoked_at = now()
+RETURNING *;
+
+-- name: GetRevokedAuthors :many
+SELECT * FROM revoked_author;
+
+-- name: RevokeFamily :exec
+INSERT INTO revoked_family (family, expires_at)
+VALUES ($1, $2)
+ON CONFLICT (family) DO NOTHING;
+
+-- name: GetRevokedFamilies :many
+SELECT family, expires_at FROM revoked_family
+WHERE expires_at > now();
+
+-- name: DeleteExpiredRevokedFamilies :exec
+DELETE FROM revoked_family
+WHERE expires_at <= now();
+
+-- name: CreateAuthor :one
+INSERT INTO author DEFAULT VALUES
+RETURNING *;
+
+-- name: GetDeviceKey :one
+SELECT * FROM device_key
+WHERE public_key = $1;
+
+-- name: CreateDeviceKey :one
+INSERT INTO device_key (author, public_key, last_ip)
+VALUES ($1, $2, $3)
+RETURNING *;
+
+-- name: TouchDeviceKey :exec
+UPDATE device_key
+SET last_used_at = now(), last_ip = $2
+WHERE id = $1;
+
+-- name: SetAuthorRole :one
+UPDATE author
+SET role = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: GetActiveBan :one
+SELECT * FROM ban
+WHERE lifted_at IS NULL 
+    AND (expires_at IS NULL OR expires_at > now())
+    AND (author = @author OR network >>= @ip::inet)
+ORDER BY expires_at DESC NULLS FIRST
+LIMIT 1;
+
+-- name: CreateBan :one
+INSERT INTO ban (author, network, reason, expires_at, created_by)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: GetBans :many
+SELECT * FROM ban
+WHERE CASE WHEN @active::bool THEN 
+    lifted_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+ELSE true END
+ORDER BY created_at DESC
+LIMIT $1 OFFSET $2;
+
+-- name: LiftBan :one
+UPDATE ban
+SET lifted_at = now(), lifted_by = $2
+WHERE id = $1 AND lifted_at IS NULL
+RETURNING *;
+
+-- name: CreateReport :one
+INSERT INTO report (author, post, comment, reason, details)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT DO NOTHING
+RETURNING *;
+
+-- name: GetOpenReports :many
+SELECT
+    reports.post,
+    reports.comment,
+    coalesce(post.author, comment.author)::uuid as "author",
+    coalesce(post.content, comment.content)::text as "content",
+    reports.count as "reports_count",
+    reports.reasons,
+    reports.first_reported_at,
+    reports.last_reported_at
+FROM (
+    SELECT
+        report.post,
+        report.comment,
+        count(report.id) as "count",
+        array_agg(DISTINCT report.reason)::text[] as "reasons",
+        min(report.created_at)::timestamptz as "first_reported_at",
+        max(report.created_at)::timestamptz as "last_reported_at"
+    FROM report
+    WHERE report.resolved_at IS NULL
+    GROUP BY report.post, report.comment
+) as reports
+LEFT JOIN post ON post.id = reports.post
+LEFT JOIN comment ON comment.id = reports.comment
+ORDER BY reports.count DESC, reports.first_reported_at ASC
+LIMIT $1 OFFSET $2;
+
+-- name: ResolveReports :execrows
+UPDATE report
+SET resolved_at = now(), resolved_by = $1, resolution = $2
+WHERE resolved_at IS NULL AND (post = @post OR comment = @comment);
+
+-- name: RestorePost :execrows
+UPDATE post
+SET deleted_at = NULL, deleted_by = NULL
+WHERE id = $1 AND deleted_at IS NOT NULL;
+
+-- name: RestoreComment :execrows
+UPDATE comment
+SET deleted_at = NULL, deleted_by = NULL
+WHERE id = $1 AND deleted_at IS NOT NULL;
+
+-- name: PurgeDeletedComments :execrows
+-- Quoted ones stay as tombstones, so quotes and backlinks still resolve
 DELETE FROM comment
+WHERE deleted_at < @deleted_before::timestamptz
+    AND NOT EXISTS (SELECT 1 FROM comment as reply WHERE reply.reply = comment.id)
+    AND NOT EXISTS (SELECT 1 FROM comment_ref WHERE comment_ref.target = comment.id);
+
+-- name: PurgeDeletedPosts :execrows
+-- Comments go with the post, so it stays while comments of other posts quote them
+DELETE FROM post
+WHERE deleted_at < @deleted_before::timestamptz
+    AND NOT EXISTS (SELECT 1 FROM comment WHERE comment.post = post.id AND comment.deleted_at IS NULL)
+    AND NOT EXISTS (
+        SELECT 1 FROM comment_ref
+        JOIN comment as target ON target.id = comment_ref.target
+        JOIN comment as source ON source.id = comment_ref.comment
+        WHERE target.post = post.id AND source.post != post.id
+    );
+
+-- name: PurgeUnusedAttachments :many
+-- Recent ones may be about to get their post or comment
+DELETE FROM attachment
+WHERE created_at < @created_before::timestamptz
+    AND NOT EXISTS (SELECT 1 FROM post WHERE post.attachment = attachment.hash)
+    AND NOT EXISTS (SELECT 1 FROM comment WHERE comment.attachment = attachment.hash)
+RETURNING *;
+
+-- name: GetFilterRules :many
+SELECT * FROM filter_rule
+WHERE enabled
+ORDER BY id;
+
+-- name: GetHeldPosts :many
+SELECT * FROM post
+WHERE visibility = 'held' AND deleted_at IS NULL
+ORDER BY created_at
+LIMIT $1 OFFSET $2;
+
+-- name: GetHeldComments :many
+SELECT * FROM comment
+WHERE visibility = 'held' AND deleted_at IS NULL
+ORDER BY created_at
+LIMIT $1 OFFSET $2;
+
+-- name: ApprovePost :execrows
+UPDATE post
+SET visibility = 'visible'
+WHERE id = $1 AND visibility != 'visible';
+
+-- name: ApproveComment :execrows
+UPDATE comment
+SET visibility = 'visible'
+WHERE id = $1 AND visibility != 'visible';
+
+-- name: SetAuthorShadowban :one
+UPDATE author
+SET shadowbanned = $2
+WHERE id = $1
+RETURNING *;
+
+-- name: GetPostById :one
+SELECT * FROM post
 WHERE id = $1;
+
+-- name: GetCommentById :one
+SELECT * FROM comment
+WHERE id = $1;
+
+-- name: GetBan :one
+SELECT * FROM ban
+WHERE id = $1;
+
+-- name: CreateModAction :one
+INSERT INTO mod_action (moderator, action, target_type, target_id, reason, before, after)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING *;
+
+-- name: GetModActions :many
+SELECT * FROM mod_action
+WHERE (sqlc.narg(moderator)::uuid IS NULL OR moderator = sqlc.narg(moderator))
+    AND (sqlc.narg(target_type)::text IS NULL OR target_type = sqlc.narg(target_type))
+    AND (sqlc.narg(target_id)::text IS NULL OR target_id = sqlc.narg(target_id))
+    AND (sqlc.narg(created_after)::timestamptz IS NULL OR created_at >= sqlc.narg(created_after))
+    AND (sqlc.narg(created_before)::timestamptz IS NULL OR created_at < sqlc.narg(created_before))
+ORDER BY created_at DESC, id DESC
+LIMIT $1 OFFSET $2;
+
+-- name: LockPost :execrows
+UPDATE post
+SET locked_at = now(), locked_by = $2
+WHERE id = $1 AND locked_at IS NULL;
+
+-- name: UnlockPost :execrows
+UPDATE post
+SET locked_at = NULL, locked_by = NULL
+WHERE id = $1 AND locked_at IS NOT NULL;
+
+-- name: ArchivePosts :execrows
+UPDATE post
+SET archived_at = now()
+WHERE archived_at IS NULL AND deleted_at IS NULL
+    AND NOT (pinned_at IS NOT NULL AND (pinned_until IS NULL OR pinned_until > now()))
+    AND (
+    post.created_at < sqlc.narg(created_before)::timestamptz
+    OR coalesce(
+        (SELECT max(comment.created_at) FROM comment WHERE comment.post = post.id),
+        post.created_at
+    ) < sqlc.narg(active_before)::timestamptz
+);
+
+-- name: PinPost :execrows
+UPDATE post
+SET pinned_at = now(), pinned_by = $2, pinned_until = $3, pin_order = $4
+WHERE id = $1 AND deleted_at IS NULL AND archived_at IS NULL;
+
+-- name: UnpinPost :execrows
+UPDATE post
+SET pinned_at = NULL, pinned_by = NULL, pinned_until = NULL, pin_order = 0
+WHERE id = $1 AND pinned_at IS NOT NULL;
+
+-- name: HasNearDuplicate :one
+SELECT EXISTS (
+    SELECT 1 FROM post
+    WHERE post.created_at > @since::timestamptz AND post.fingerprint IS NOT NULL AND post.id != @exclude_post::int
+        AND bit_count((post.fingerprint # @fingerprint::bigint)::bit(64)) <= @max_distance::int
+) OR EXISTS (
+    SELECT 1 FROM comment
+    WHERE comment.created_at > @since::timestamptz AND comment.fingerprint IS NOT NULL AND comment.id != @exclude_comment::int
+        AND bit_count((comment.fingerprint # @fingerprint::bigint)::bit(64)) <= @max_distance::int
+) as "duplicate";
+
+-- name: GetBoards :many
+SELECT * FROM board
+ORDER BY slug;
+
+-- name: GetBoard :one
+SELECT * FROM board
+WHERE slug = $1;
+
+-- name: SetBoard :one
+INSERT INTO board (slug, title, description, settings)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (slug)
+DO UPDATE SET title = excluded.title, description = excluded.description, settings = excluded.settings
+RETURNING *;
+
+-- name: GetAttachment :one
+SELECT * FROM attachment
+WHERE hash = $1;
+
+-- name: IsFileServed :one
+-- Files of attachments whose posts and comments are all deleted are not served
+SELECT EXISTS (
+    SELECT 1 FROM attachment
+    WHERE (attachment.file_key = @key OR attachment.thumb_key = @key)
+        AND (
+            EXISTS (SELECT 1 FROM post WHERE post.attachment = attachment.hash AND post.deleted_at IS NULL)
+            OR EXISTS (SELECT 1 FROM comment WHERE comment.attachment = attachment.hash AND comment.deleted_at IS NULL)
+        )
+) as "served";
+
+-- name: CreateAttachment :one
+INSERT INTO attachment (hash, content_type, size, width, height, file_key, thumb_key, thumb_width, thumb_height)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (hash)
+DO UPDATE SET hash = excluded.hash
+RETURNING *;
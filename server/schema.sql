@@ -1,34 +1,321 @@
 CREATE TABLE author (
   id uuid PRIMARY KEY DEFAULT gen_random_uuid (),
-  ip inet NOT NULL UNIQUE
+  ip inet UNIQUE, -- Empty for authors identified by a device key
+  role text DEFAULT 'user' NOT NULL CHECK (role IN ('user', 'moderator', 'admin')),
+  shadowbanned boolean DEFAULT false NOT NULL -- Everything they write is only shown to themselves
 );
 
+CREATE TABLE board (
+  id serial PRIMARY KEY,
+  slug text UNIQUE NOT NULL CHECK (slug ~ '^[a-z0-9_]{1,32}$'),
+  title text NOT NULL,
+  description text DEFAULT '' NOT NULL,
+  settings jsonb DEFAULT '{}' NOT NULL, -- See boardSettings in api/boards.go
+  created_at timestamptz DEFAULT now () NOT NULL
+);
+
+INSERT INTO board (slug, title) VALUES ('b', 'Random');
+
+CREATE TABLE attachment (
+  hash text PRIMARY KEY, -- sha256 of the stored file, same files are stored once
+  content_type text NOT NULL,
+  size integer NOT NULL,
+  width integer NOT NULL,
+  height integer NOT NULL,
+  file_key text UNIQUE NOT NULL,
+  thumb_key text UNIQUE NOT NULL,
+  thumb_width integer NOT NULL,
+  thumb_height integer NOT NULL,
+  created_at timestamptz DEFAULT now () NOT NULL
+);
+
+-- Attachment the way the api returns it, null when there is none
+CREATE FUNCTION attachment_json (a attachment) RETURNS jsonb AS $$
+  SELECT CASE WHEN a.hash IS NULL THEN NULL ELSE jsonb_build_object(
+    'hash', a.hash,
+    'contentType', a.content_type,
+    'size', a.size,
+    'width', a.width,
+    'height', a.height,
+    'fileKey', a.file_key,
+    'thumbKey', a.thumb_key,
+    'thumbWidth', a.thumb_width,
+    'thumbHeight', a.thumb_height,
+    'createdAt', a.created_at
+  ) END
+$$ LANGUAGE sql STABLE;
+
 CREATE TABLE post (
   id serial PRIMARY KEY,
   author uuid REFERENCES author (id) NOT NULL,
   created_at timestamptz DEFAULT now () NOT NULL,
-  content text NOT NULL
+  content text NOT NULL,
+  name text,
+  tripcode text,
+  deleted_at timestamptz,
+  deleted_by uuid REFERENCES author (id),
+  visibility text DEFAULT 'visible' NOT NULL CHECK (visibility IN ('visible', 'held', 'shadow')), -- Held and shadow are only shown to the author
+  locked_at timestamptz, -- Locked and archived posts take no new comments
+  locked_by uuid REFERENCES author (id),
+  archived_at timestamptz,
+  pinned_at timestamptz, -- Pinned posts come first in the feed until pinned_until
+  pinned_by uuid REFERENCES author (id),
+  pinned_until timestamptz,
+  pin_order int DEFAULT 0 NOT NULL,
+  fingerprint bigint, -- Simhash of the content, null when it is too short to compare
+  board integer REFERENCES board (id) NOT NULL,
+  content_html text DEFAULT '' NOT NULL, -- Rendered from content when it is written
+  attachment text REFERENCES attachment (hash),
+  edited_at timestamptz -- Previous versions are in post_revision
 );
 
+CREATE INDEX idx_post_board ON post (board, created_at);
+
+CREATE INDEX idx_post_fingerprint ON post (created_at) WHERE fingerprint IS NOT NULL;
+
+CREATE INDEX idx_post_attachment ON post (attachment);
+
+-- Content of a post before an edit, created_at is when that version was written
+CREATE TABLE post_revision (
+  id serial PRIMARY KEY,
+  post integer REFERENCES post (id) ON DELETE CASCADE NOT NULL,
+  content text NOT NULL,
+  content_html text NOT NULL,
+  created_at timestamptz NOT NULL,
+  replaced_at timestamptz DEFAULT now () NOT NULL
+);
+
+CREATE INDEX idx_post_revision_post ON post_revision (post);
+
 CREATE TABLE post_like (
   author uuid REFERENCES author (id) NOT NULL,
   post integer REFERENCES post (id) ON DELETE CASCADE NOT NULL,
   PRIMARY KEY (author, post)
 );
 
+CREATE TABLE poll (
+  post integer PRIMARY KEY REFERENCES post (id) ON DELETE CASCADE,
+  question text NOT NULL,
+  options text[] NOT NULL CHECK (cardinality(options) BETWEEN 2 AND 10),
+  multiple boolean DEFAULT false NOT NULL,
+  closes_at timestamptz,
+  created_at timestamptz DEFAULT now () NOT NULL
+);
+
+-- One row per author and poll, holding indexes into poll.options
+CREATE TABLE poll_vote (
+  poll integer REFERENCES poll (post) ON DELETE CASCADE NOT NULL,
+  author uuid REFERENCES author (id) NOT NULL,
+  options smallint[] NOT NULL CHECK (cardinality(options) > 0),
+  created_at timestamptz DEFAULT now () NOT NULL,
+  PRIMARY KEY (poll, author)
+);
+
+-- Poll the way the api returns it to the viewer, null when there is none.
+-- Votes of shadowbanned authors only count for themselves.
+CREATE FUNCTION poll_json (p poll, viewer uuid) RETURNS jsonb AS $$
+  WITH votes AS (
+    SELECT poll_vote.author, poll_vote.options FROM poll_vote
+    JOIN author ON author.id = poll_vote.author
+    WHERE poll_vote.poll = p.post AND (NOT author.shadowbanned OR author.id = viewer)
+  )
+  SELECT CASE WHEN p.post IS NULL THEN NULL ELSE jsonb_build_object(
+    'question', p.question,
+    'options', (
+      SELECT jsonb_agg(jsonb_build_object(
+        'text', o.text,
+        'votesCount', (SELECT count(*) FROM votes WHERE o.ord - 1 = ANY (votes.options))
+      ) ORDER BY o.ord)
+      FROM unnest(p.options) WITH ORDINALITY AS o (text, ord)
+    ),
+    'multiple', p.multiple,
+    'closesAt', p.closes_at,
+    'isClosed', (p.closes_at IS NOT NULL AND p.closes_at <= now())
+      OR EXISTS (SELECT 1 FROM post WHERE post.id = p.post AND post.archived_at IS NOT NULL),
+    'votersCount', (SELECT count(*) FROM votes),
+    'myVote', (SELECT to_jsonb(votes.options) FROM votes WHERE votes.author = viewer)
+  ) END
+$$ LANGUAGE sql STABLE;
+
 CREATE TABLE comment (
   id serial PRIMARY KEY,
   post integer REFERENCES post (id) ON DELETE CASCADE NOT NULL,
   author uuid REFERENCES author (id) NOT NULL,
-  reply integer REFERENCES comment (id),
+  reply integer REFERENCES comment (id) ON DELETE SET NULL,
   content text NOT NULL,
-  created_at timestamptz DEFAULT now () NOT NULL
+  created_at timestamptz DEFAULT now () NOT NULL,
+  name text,
+  tripcode text,
+  deleted_at timestamptz,
+  deleted_by uuid REFERENCES author (id),
+  visibility text DEFAULT 'visible' NOT NULL CHECK (visibility IN ('visible', 'held', 'shadow')), -- Held and shadow are only shown to the author
+  fingerprint bigint,
+  content_html text DEFAULT '' NOT NULL,
+  attachment text REFERENCES attachment (hash),
+  edited_at timestamptz
 );
 
 CREATE INDEX idx_comment_post ON comment (post);
 
+CREATE INDEX idx_comment_reply ON comment (reply);
+
+CREATE INDEX idx_comment_fingerprint ON comment (created_at) WHERE fingerprint IS NOT NULL;
+
+CREATE INDEX idx_comment_attachment ON comment (attachment);
+
+-- Same as post_revision
+CREATE TABLE comment_revision (
+  id serial PRIMARY KEY,
+  comment integer REFERENCES comment (id) ON DELETE CASCADE NOT NULL,
+  content text NOT NULL,
+  content_html text NOT NULL,
+  created_at timestamptz NOT NULL,
+  replaced_at timestamptz DEFAULT now () NOT NULL
+);
+
+CREATE INDEX idx_comment_revision_comment ON comment_revision (comment);
+
+-- '>>123' references in the content of a comment, to comments of any post
+CREATE TABLE comment_ref (
+  comment integer REFERENCES comment (id) ON DELETE CASCADE NOT NULL,
+  target integer REFERENCES comment (id) ON DELETE CASCADE NOT NULL,
+  PRIMARY KEY (comment, target)
+);
+
+CREATE INDEX idx_comment_ref_target ON comment_ref (target);
+
+-- Comments referenced by the comment, the way the api returns them
+CREATE FUNCTION comment_quotes (c integer) RETURNS jsonb AS $$
+  SELECT coalesce(jsonb_agg(jsonb_build_object('id', target.id, 'post', target.post) ORDER BY target.id), '[]')
+  FROM comment_ref
+  JOIN comment as target ON target.id = comment_ref.target
+  WHERE comment_ref.comment = c
+$$ LANGUAGE sql STABLE;
+
+-- Comments referencing the comment that the viewer can see
+CREATE FUNCTION comment_backlinks (c integer, viewer uuid) RETURNS jsonb AS $$
+  SELECT coalesce(jsonb_agg(jsonb_build_object('id', source.id, 'post', source.post) ORDER BY source.id), '[]')
+  FROM comment_ref
+  JOIN comment as source ON source.id = comment_ref.comment
+  JOIN author ON author.id = source.author
+  WHERE comment_ref.target = c AND source.deleted_at IS NULL
+    AND (source.author = viewer OR (source.visibility = 'visible' AND NOT author.shadowbanned))
+$$ LANGUAGE sql STABLE;
+
 CREATE TABLE comment_like (
   author uuid REFERENCES author (id) NOT NULL,
   comment integer REFERENCES comment (id) ON DELETE CASCADE NOT NULL,
   PRIMARY KEY (author, comment)
 );
+
+CREATE TABLE refresh_token (
+  id uuid PRIMARY KEY DEFAULT gen_random_uuid (),
+  family uuid NOT NULL,
+  author uuid REFERENCES author (id) NOT NULL,
+  token_hash bytea NOT NULL UNIQUE,
+  created_at timestamptz DEFAULT now () NOT NULL,
+  expires_at timestamptz NOT NULL,
+  used_at timestamptz,
+  revoked_at timestamptz
+);
+
+CREATE INDEX idx_refresh_token_family ON refresh_token (family);
+
+CREATE TABLE revoked_token (
+  jti uuid PRIMARY KEY,
+  author uuid REFERENCES author (id) NOT NULL,
+  expires_at timestamptz NOT NULL,
+  revoked_at timestamptz DEFAULT now () NOT NULL
+);
+
+CREATE TABLE revoked_author (
+  author uuid PRIMARY KEY REFERENCES author (id),
+  revoked_at timestamptz DEFAULT now () NOT NULL
+);
+
+-- Refresh token families caught reusing a token, with the expiry of their last access token
+CREATE TABLE revoked_family (
+  family uuid PRIMARY KEY,
+  expires_at timestamptz NOT NULL,
+  revoked_at timestamptz DEFAULT now () NOT NULL
+);
+
+CREATE TABLE device_key (
+  id serial PRIMARY KEY,
+  author uuid REFERENCES author (id) NOT NULL,
+  public_key bytea NOT NULL UNIQUE,
+  created_at timestamptz DEFAULT now () NOT NULL,
+  last_used_at timestamptz DEFAULT now () NOT NULL,
+  last_ip inet
+);
+
+CREATE TABLE ban (
+  id serial PRIMARY KEY,
+  author uuid REFERENCES author (id),
+  network cidr, -- Single ip is stored as /32 or /128
+  reason text NOT NULL,
+  expires_at timestamptz, -- Permanent when empty
+  created_by uuid REFERENCES author (id) NOT NULL,
+  created_at timestamptz DEFAULT now () NOT NULL,
+  lifted_at timestamptz,
+  lifted_by uuid REFERENCES author (id),
+  CHECK ((author IS NULL) != (network IS NULL))
+);
+
+CREATE INDEX idx_ban_author ON ban (author);
+
+CREATE TABLE report (
+  id serial PRIMARY KEY,
+  author uuid REFERENCES author (id) NOT NULL,
+  post integer REFERENCES post (id) ON DELETE CASCADE,
+  comment integer REFERENCES comment (id) ON DELETE CASCADE,
+  reason text NOT NULL CHECK (reason IN ('spam', 'abuse', 'illegal', 'off_topic', 'other')),
+  details text,
+  created_at timestamptz DEFAULT now () NOT NULL,
+  resolved_at timestamptz,
+  resolved_by uuid REFERENCES author (id),
+  resolution text CHECK (resolution IN ('dismiss', 'delete', 'ban')),
+  CHECK ((post IS NULL) != (comment IS NULL)),
+  UNIQUE (author, post),
+  UNIQUE (author, comment)
+);
+
+CREATE INDEX idx_report_open ON report (post, comment) WHERE resolved_at IS NULL;
+
+CREATE TABLE filter_rule (
+  id serial PRIMARY KEY,
+  kind text NOT NULL CHECK (kind IN ('regex', 'words', 'links', 'repeat')),
+  pattern text DEFAULT '' NOT NULL, -- Regex, or comma / newline separated words
+  threshold integer DEFAULT 0 NOT NULL, -- Max links, or max repeats of one character
+  action text NOT NULL CHECK (action IN ('reject', 'replace', 'hold', 'shadow')),
+  message text DEFAULT '' NOT NULL,
+  replacement text DEFAULT '' NOT NULL,
+  dry_run boolean DEFAULT false NOT NULL,
+  enabled boolean DEFAULT true NOT NULL
+);
+
+-- Append-only, the trigger below refuses updates and deletes
+CREATE TABLE mod_action (
+  id serial PRIMARY KEY,
+  moderator uuid REFERENCES author (id) NOT NULL,
+  action text NOT NULL,
+  target_type text NOT NULL CHECK (target_type IN ('post', 'comment', 'author', 'ban', 'board')),
+  target_id text NOT NULL,
+  reason text,
+  before jsonb, -- Snapshots of the target, empty when it did not exist before or after
+  after jsonb,
+  created_at timestamptz DEFAULT now () NOT NULL
+);
+
+CREATE INDEX idx_mod_action_moderator ON mod_action (moderator, created_at);
+
+CREATE INDEX idx_mod_action_target ON mod_action (target_type, target_id);
+
+CREATE FUNCTION mod_action_append_only () RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'mod_action is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER mod_action_append_only BEFORE UPDATE OR DELETE ON mod_action
+FOR EACH ROW EXECUTE FUNCTION mod_action_append_only ();
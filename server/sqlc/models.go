@@ -5,24 +5,70 @@
 package sqlc
 
 import (
+	"encoding/json"
 	"net/netip"
 
 	"github.com/gofrs/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
+type Attachment struct {
+	Hash        string             `json:"hash"`
+	ContentType string             `json:"contentType"`
+	Size        int32              `json:"size"`
+	Width       int32              `json:"width"`
+	Height      int32              `json:"height"`
+	FileKey     string             `json:"fileKey"`
+	ThumbKey    string             `json:"thumbKey"`
+	ThumbWidth  int32              `json:"thumbWidth"`
+	ThumbHeight int32              `json:"thumbHeight"`
+	CreatedAt   pgtype.Timestamptz `json:"createdAt"`
+}
+
 type Author struct {
-	ID uuid.UUID  `json:"id"`
-	Ip netip.Addr `json:"ip"`
+	ID           uuid.UUID   `json:"id"`
+	Ip           *netip.Addr `json:"ip"`
+	Role         string      `json:"role"`
+	Shadowbanned bool        `json:"shadowbanned"`
 }
 
-type Comment struct {
+type Ban struct {
 	ID        int32              `json:"id"`
-	Post      int32              `json:"post"`
-	Author    uuid.UUID          `json:"author"`
-	Reply     pgtype.Int4        `json:"reply"`
-	Content   string             `json:"content"`
+	Author    pgtype.UUID        `json:"author"`
+	Network   *netip.Prefix      `json:"network"`
+	Reason    string             `json:"reason"`
+	ExpiresAt pgtype.Timestamptz `json:"expiresAt"`
+	CreatedBy uuid.UUID          `json:"createdBy"`
 	CreatedAt pgtype.Timestamptz `json:"createdAt"`
+	LiftedAt  pgtype.Timestamptz `json:"liftedAt"`
+	LiftedBy  pgtype.UUID        `json:"liftedBy"`
+}
+
+type Board struct {
+	ID          int32              `json:"id"`
+	Slug        string             `json:"slug"`
+	Title       string             `json:"title"`
+	Description string             `json:"description"`
+	Settings    json.RawMessage    `json:"settings"`
+	CreatedAt   pgtype.Timestamptz `json:"createdAt"`
+}
+
+type Comment struct {
+	ID          int32              `json:"id"`
+	Post        int32              `json:"post"`
+	Author      uuid.UUID          `json:"author"`
+	Reply       pgtype.Int4        `json:"reply"`
+	Content     string             `json:"content"`
+	CreatedAt   pgtype.Timestamptz `json:"createdAt"`
+	Name        pgtype.Text        `json:"name"`
+	Tripcode    pgtype.Text        `json:"tripcode"`
+	DeletedAt   pgtype.Timestamptz `json:"deletedAt"`
+	DeletedBy   pgtype.UUID        `json:"deletedBy"`
+	Visibility  string             `json:"visibility"`
+	Fingerprint pgtype.Int8        `json:"fingerprint"`
+	ContentHtml string             `json:"contentHtml"`
+	Attachment  pgtype.Text        `json:"attachment"`
+	EditedAt    pgtype.Timestamptz `json:"editedAt"`
 }
 
 type CommentLike struct {
@@ -30,14 +76,145 @@ type CommentLike struct {
 	Comment int32     `json:"comment"`
 }
 
-type Post struct {
-	ID        int32              `json:"id"`
+type CommentRef struct {
+	Comment int32 `json:"comment"`
+	Target  int32 `json:"target"`
+}
+
+type CommentRevision struct {
+	ID          int32              `json:"id"`
+	Comment     int32              `json:"comment"`
+	Content     string             `json:"content"`
+	ContentHtml string             `json:"contentHtml"`
+	CreatedAt   pgtype.Timestamptz `json:"createdAt"`
+	ReplacedAt  pgtype.Timestamptz `json:"replacedAt"`
+}
+
+type DeviceKey struct {
+	ID         int32              `json:"id"`
+	Author     uuid.UUID          `json:"author"`
+	PublicKey  []byte             `json:"publicKey"`
+	CreatedAt  pgtype.Timestamptz `json:"createdAt"`
+	LastUsedAt pgtype.Timestamptz `json:"lastUsedAt"`
+	LastIp     *netip.Addr        `json:"lastIp"`
+}
+
+type FilterRule struct {
+	ID          int32  `json:"id"`
+	Kind        string `json:"kind"`
+	Pattern     string `json:"pattern"`
+	Threshold   int32  `json:"threshold"`
+	Action      string `json:"action"`
+	Message     string `json:"message"`
+	Replacement string `json:"replacement"`
+	DryRun      bool   `json:"dryRun"`
+	Enabled     bool   `json:"enabled"`
+}
+
+type ModAction struct {
+	ID         int32              `json:"id"`
+	Moderator  uuid.UUID          `json:"moderator"`
+	Action     string             `json:"action"`
+	TargetType string             `json:"targetType"`
+	TargetID   string             `json:"targetId"`
+	Reason     pgtype.Text        `json:"reason"`
+	Before     json.RawMessage    `json:"before"`
+	After      json.RawMessage    `json:"after"`
+	CreatedAt  pgtype.Timestamptz `json:"createdAt"`
+}
+
+type Poll struct {
+	Post      int32              `json:"post"`
+	Question  string             `json:"question"`
+	Options   []string           `json:"options"`
+	Multiple  bool               `json:"multiple"`
+	ClosesAt  pgtype.Timestamptz `json:"closesAt"`
+	CreatedAt pgtype.Timestamptz `json:"createdAt"`
+}
+
+type PollVote struct {
+	Poll      int32              `json:"poll"`
 	Author    uuid.UUID          `json:"author"`
+	Options   []int16            `json:"options"`
 	CreatedAt pgtype.Timestamptz `json:"createdAt"`
-	Content   string             `json:"content"`
+}
+
+type Post struct {
+	ID          int32              `json:"id"`
+	Author      uuid.UUID          `json:"author"`
+	CreatedAt   pgtype.Timestamptz `json:"createdAt"`
+	Content     string             `json:"content"`
+	Name        pgtype.Text        `json:"name"`
+	Tripcode    pgtype.Text        `json:"tripcode"`
+	DeletedAt   pgtype.Timestamptz `json:"deletedAt"`
+	DeletedBy   pgtype.UUID        `json:"deletedBy"`
+	Visibility  string             `json:"visibility"`
+	LockedAt    pgtype.Timestamptz `json:"lockedAt"`
+	LockedBy    pgtype.UUID        `json:"lockedBy"`
+	ArchivedAt  pgtype.Timestamptz `json:"archivedAt"`
+	PinnedAt    pgtype.Timestamptz `json:"pinnedAt"`
+	PinnedBy    pgtype.UUID        `json:"pinnedBy"`
+	PinnedUntil pgtype.Timestamptz `json:"pinnedUntil"`
+	PinOrder    int32              `json:"pinOrder"`
+	Fingerprint pgtype.Int8        `json:"fingerprint"`
+	Board       int32              `json:"board"`
+	ContentHtml string             `json:"contentHtml"`
+	Attachment  pgtype.Text        `json:"attachment"`
+	EditedAt    pgtype.Timestamptz `json:"editedAt"`
 }
 
 type PostLike struct {
 	Author uuid.UUID `json:"author"`
 	Post   int32     `json:"post"`
 }
+
+type PostRevision struct {
+	ID          int32              `json:"id"`
+	Post        int32              `json:"post"`
+	Content     string             `json:"content"`
+	ContentHtml string             `json:"contentHtml"`
+	CreatedAt   pgtype.Timestamptz `json:"createdAt"`
+	ReplacedAt  pgtype.Timestamptz `json:"replacedAt"`
+}
+
+type RefreshToken struct {
+	ID        uuid.UUID          `json:"id"`
+	Family    uuid.UUID          `json:"family"`
+	Author    uuid.UUID          `json:"author"`
+	TokenHash []byte             `json:"tokenHash"`
+	CreatedAt pgtype.Timestamptz `json:"createdAt"`
+	ExpiresAt pgtype.Timestamptz `json:"expiresAt"`
+	UsedAt    pgtype.Timestamptz `json:"usedAt"`
+	RevokedAt pgtype.Timestamptz `json:"revokedAt"`
+}
+
+type Report struct {
+	ID         int32              `json:"id"`
+	Author     uuid.UUID          `json:"author"`
+	Post       pgtype.Int4        `json:"post"`
+	Comment    pgtype.Int4        `json:"comment"`
+	Reason     string             `json:"reason"`
+	Details    pgtype.Text        `json:"details"`
+	CreatedAt  pgtype.Timestamptz `json:"createdAt"`
+	ResolvedAt pgtype.Timestamptz `json:"resolvedAt"`
+	ResolvedBy pgtype.UUID        `json:"resolvedBy"`
+	Resolution pgtype.Text        `json:"resolution"`
+}
+
+type RevokedAuthor struct {
+	Author    uuid.UUID          `json:"author"`
+	RevokedAt pgtype.Timestamptz `json:"revokedAt"`
+}
+
+type RevokedFamily struct {
+	Family    uuid.UUID          `json:"family"`
+	ExpiresAt pgtype.Timestamptz `json:"expiresAt"`
+	RevokedAt pgtype.Timestamptz `json:"revokedAt"`
+}
+
+type RevokedToken struct {
+	Jti       uuid.UUID          `json:"jti"`
+	Author    uuid.UUID          `json:"author"`
+	ExpiresAt pgtype.Timestamptz `json:"expiresAt"`
+	RevokedAt pgtype.Timestamptz `json:"revokedAt"`
+}
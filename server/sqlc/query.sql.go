@@ -7,42 +7,225 @@ package sqlc
 
 import (
 	"context"
+	"encoding/json"
 	"net/netip"
 
 	"github.com/gofrs/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-const auth = `-- name: Auth :one
+const approveComment = `-- name: ApproveComment :execrows
+UPDATE comment
+SET visibility = 'visible'
+WHERE id = $1 AND visibility != 'visible'
+`
+
+func (q *Queries) ApproveComment(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, approveComment, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const approvePost = `-- name: ApprovePost :execrows
+UPDATE post
+SET visibility = 'visible'
+WHERE id = $1 AND visibility != 'visible'
+`
+
+func (q *Queries) ApprovePost(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, approvePost, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const archivePosts = `-- name: ArchivePosts :execrows
+UPDATE post
+SET archived_at = now()
+WHERE archived_at IS NULL AND deleted_at IS NULL
+    AND NOT (pinned_at IS NOT NULL AND (pinned_until IS NULL OR pinned_until > now()))
+    AND (
+    post.created_at < $1::timestamptz
+    OR coalesce(
+        (SELECT max(comment.created_at) FROM comment WHERE comment.post = post.id),
+        post.created_at
+    ) < $2::timestamptz
+)
+`
+
+type ArchivePostsParams struct {
+	CreatedBefore pgtype.Timestamptz `json:"createdBefore"`
+	ActiveBefore  pgtype.Timestamptz `json:"activeBefore"`
+}
+
+func (q *Queries) ArchivePosts(ctx context.Context, arg ArchivePostsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, archivePosts, arg.CreatedBefore, arg.ActiveBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const auth = `-- name: Auth :one 
 INSERT INTO author (ip)
 VALUES ($1)
 ON CONFLICT (ip)
 DO UPDATE SET 
     ip = $1
-RETURNING id, ip
+RETURNING id, ip, role, shadowbanned
 `
 
-func (q *Queries) Auth(ctx context.Context, ip netip.Addr) (Author, error) {
+func (q *Queries) Auth(ctx context.Context, ip *netip.Addr) (Author, error) {
 	row := q.db.QueryRow(ctx, auth, ip)
 	var i Author
-	err := row.Scan(&i.ID, &i.Ip)
+	err := row.Scan(
+		&i.ID,
+		&i.Ip,
+		&i.Role,
+		&i.Shadowbanned,
+	)
+	return i, err
+}
+
+const createAttachment = `-- name: CreateAttachment :one
+INSERT INTO attachment (hash, content_type, size, width, height, file_key, thumb_key, thumb_width, thumb_height)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+ON CONFLICT (hash)
+DO UPDATE SET hash = excluded.hash
+RETURNING hash, content_type, size, width, height, file_key, thumb_key, thumb_width, thumb_height, created_at
+`
+
+type CreateAttachmentParams struct {
+	Hash        string `json:"hash"`
+	ContentType string `json:"contentType"`
+	Size        int32  `json:"size"`
+	Width       int32  `json:"width"`
+	Height      int32  `json:"height"`
+	FileKey     string `json:"fileKey"`
+	ThumbKey    string `json:"thumbKey"`
+	ThumbWidth  int32  `json:"thumbWidth"`
+	ThumbHeight int32  `json:"thumbHeight"`
+}
+
+func (q *Queries) CreateAttachment(ctx context.Context, arg CreateAttachmentParams) (Attachment, error) {
+	row := q.db.QueryRow(ctx, createAttachment,
+		arg.Hash,
+		arg.ContentType,
+		arg.Size,
+		arg.Width,
+		arg.Height,
+		arg.FileKey,
+		arg.ThumbKey,
+		arg.ThumbWidth,
+		arg.ThumbHeight,
+	)
+	var i Attachment
+	err := row.Scan(
+		&i.Hash,
+		&i.ContentType,
+		&i.Size,
+		&i.Width,
+		&i.Height,
+		&i.FileKey,
+		&i.ThumbKey,
+		&i.ThumbWidth,
+		&i.ThumbHeight,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createAuthor = `-- name: CreateAuthor :one
+INSERT INTO author DEFAULT VALUES
+RETURNING id, ip, role, shadowbanned
+`
+
+func (q *Queries) CreateAuthor(ctx context.Context) (Author, error) {
+	row := q.db.QueryRow(ctx, createAuthor)
+	var i Author
+	err := row.Scan(
+		&i.ID,
+		&i.Ip,
+		&i.Role,
+		&i.Shadowbanned,
+	)
+	return i, err
+}
+
+const createBan = `-- name: CreateBan :one
+INSERT INTO ban (author, network, reason, expires_at, created_by)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING id, author, network, reason, expires_at, created_by, created_at, lifted_at, lifted_by
+`
+
+type CreateBanParams struct {
+	Author    pgtype.UUID        `json:"author"`
+	Network   *netip.Prefix      `json:"network"`
+	Reason    string             `json:"reason"`
+	ExpiresAt pgtype.Timestamptz `json:"expiresAt"`
+	CreatedBy uuid.UUID          `json:"createdBy"`
+}
+
+func (q *Queries) CreateBan(ctx context.Context, arg CreateBanParams) (Ban, error) {
+	row := q.db.QueryRow(ctx, createBan,
+		arg.Author,
+		arg.Network,
+		arg.Reason,
+		arg.ExpiresAt,
+		arg.CreatedBy,
+	)
+	var i Ban
+	err := row.Scan(
+		&i.ID,
+		&i.Author,
+		&i.Network,
+		&i.Reason,
+		&i.ExpiresAt,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.LiftedAt,
+		&i.LiftedBy,
+	)
 	return i, err
 }
 
 const createComment = `-- name: CreateComment :one
-INSERT INTO comment (author, post, content)
-VALUES ($1, $2, $3)
-RETURNING id, post, author, reply, content, created_at
+INSERT INTO comment (author, post, content, name, tripcode, visibility, fingerprint, content_html, attachment)
+SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9
+WHERE EXISTS (
+    SELECT 1 FROM post 
+    WHERE post.id = $2 AND post.deleted_at IS NULL AND post.locked_at IS NULL AND post.archived_at IS NULL
+)
+RETURNING id, post, author, reply, content, created_at, name, tripcode, deleted_at, deleted_by, visibility, fingerprint, content_html, attachment, edited_at
 `
 
 type CreateCommentParams struct {
-	Author  uuid.UUID `json:"author"`
-	Post    int32     `json:"post"`
-	Content string    `json:"content"`
+	Author      uuid.UUID   `json:"author"`
+	Post        int32       `json:"post"`
+	Content     string      `json:"content"`
+	Name        pgtype.Text `json:"name"`
+	Tripcode    pgtype.Text `json:"tripcode"`
+	Visibility  string      `json:"visibility"`
+	Fingerprint pgtype.Int8 `json:"fingerprint"`
+	ContentHtml string      `json:"contentHtml"`
+	Attachment  pgtype.Text `json:"attachment"`
 }
 
 func (q *Queries) CreateComment(ctx context.Context, arg CreateCommentParams) (Comment, error) {
-	row := q.db.QueryRow(ctx, createComment, arg.Author, arg.Post, arg.Content)
+	row := q.db.QueryRow(ctx, createComment,
+		arg.Author,
+		arg.Post,
+		arg.Content,
+		arg.Name,
+		arg.Tripcode,
+		arg.Visibility,
+		arg.Fingerprint,
+		arg.ContentHtml,
+		arg.Attachment,
+	)
 	var i Comment
 	err := row.Scan(
 		&i.ID,
@@ -51,21 +234,79 @@ func (q *Queries) CreateComment(ctx context.Context, arg CreateCommentParams) (C
 		&i.Reply,
 		&i.Content,
 		&i.CreatedAt,
+		&i.Name,
+		&i.Tripcode,
+		&i.DeletedAt,
+		&i.DeletedBy,
+		&i.Visibility,
+		&i.Fingerprint,
+		&i.ContentHtml,
+		&i.Attachment,
+		&i.EditedAt,
+	)
+	return i, err
+}
+
+const createCommentRefs = `-- name: CreateCommentRefs :exec
+INSERT INTO comment_ref (comment, target)
+SELECT $1::int, unnest($2::int[])
+ON CONFLICT DO NOTHING
+`
+
+type CreateCommentRefsParams struct {
+	Comment int32   `json:"comment"`
+	Targets []int32 `json:"targets"`
+}
+
+func (q *Queries) CreateCommentRefs(ctx context.Context, arg CreateCommentRefsParams) error {
+	_, err := q.db.Exec(ctx, createCommentRefs, arg.Comment, arg.Targets)
+	return err
+}
+
+const createCommentRevision = `-- name: CreateCommentRevision :one
+INSERT INTO comment_revision (comment, content, content_html, created_at)
+SELECT comment.id, comment.content, comment.content_html, coalesce(comment.edited_at, comment.created_at) FROM comment
+WHERE comment.id = $1
+FOR UPDATE
+RETURNING id, comment, content, content_html, created_at, replaced_at
+`
+
+// Locks the comment until the transaction ends, so concurrent edits keep every version
+func (q *Queries) CreateCommentRevision(ctx context.Context, comment int32) (CommentRevision, error) {
+	row := q.db.QueryRow(ctx, createCommentRevision, comment)
+	var i CommentRevision
+	err := row.Scan(
+		&i.ID,
+		&i.Comment,
+		&i.Content,
+		&i.ContentHtml,
+		&i.CreatedAt,
+		&i.ReplacedAt,
 	)
 	return i, err
 }
 
 const createCommentWithReply = `-- name: CreateCommentWithReply :one
-INSERT INTO comment (author, post, content, reply)
-VALUES ($1, $2, $3, $4)
-RETURNING id, post, author, reply, content, created_at
+INSERT INTO comment (author, post, content, reply, name, tripcode, visibility, fingerprint, content_html, attachment)
+SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+WHERE EXISTS (
+    SELECT 1 FROM post 
+    WHERE post.id = $2 AND post.deleted_at IS NULL AND post.locked_at IS NULL AND post.archived_at IS NULL
+)
+RETURNING id, post, author, reply, content, created_at, name, tripcode, deleted_at, deleted_by, visibility, fingerprint, content_html, attachment, edited_at
 `
 
 type CreateCommentWithReplyParams struct {
-	Author  uuid.UUID   `json:"author"`
-	Post    int32       `json:"post"`
-	Content string      `json:"content"`
-	Reply   pgtype.Int4 `json:"reply"`
+	Author      uuid.UUID   `json:"author"`
+	Post        int32       `json:"post"`
+	Content     string      `json:"content"`
+	Reply       pgtype.Int4 `json:"reply"`
+	Name        pgtype.Text `json:"name"`
+	Tripcode    pgtype.Text `json:"tripcode"`
+	Visibility  string      `json:"visibility"`
+	Fingerprint pgtype.Int8 `json:"fingerprint"`
+	ContentHtml string      `json:"contentHtml"`
+	Attachment  pgtype.Text `json:"attachment"`
 }
 
 func (q *Queries) CreateCommentWithReply(ctx context.Context, arg CreateCommentWithReplyParams) (Comment, error) {
@@ -74,6 +315,12 @@ func (q *Queries) CreateCommentWithReply(ctx context.Context, arg CreateCommentW
 		arg.Post,
 		arg.Content,
 		arg.Reply,
+		arg.Name,
+		arg.Tripcode,
+		arg.Visibility,
+		arg.Fingerprint,
+		arg.ContentHtml,
+		arg.Attachment,
 	)
 	var i Comment
 	err := row.Scan(
@@ -83,209 +330,1232 @@ func (q *Queries) CreateCommentWithReply(ctx context.Context, arg CreateCommentW
 		&i.Reply,
 		&i.Content,
 		&i.CreatedAt,
+		&i.Name,
+		&i.Tripcode,
+		&i.DeletedAt,
+		&i.DeletedBy,
+		&i.Visibility,
+		&i.Fingerprint,
+		&i.ContentHtml,
+		&i.Attachment,
+		&i.EditedAt,
+	)
+	return i, err
+}
+
+const createDeviceKey = `-- name: CreateDeviceKey :one
+INSERT INTO device_key (author, public_key, last_ip)
+VALUES ($1, $2, $3)
+RETURNING id, author, public_key, created_at, last_used_at, last_ip
+`
+
+type CreateDeviceKeyParams struct {
+	Author    uuid.UUID   `json:"author"`
+	PublicKey []byte      `json:"publicKey"`
+	LastIp    *netip.Addr `json:"lastIp"`
+}
+
+func (q *Queries) CreateDeviceKey(ctx context.Context, arg CreateDeviceKeyParams) (DeviceKey, error) {
+	row := q.db.QueryRow(ctx, createDeviceKey, arg.Author, arg.PublicKey, arg.LastIp)
+	var i DeviceKey
+	err := row.Scan(
+		&i.ID,
+		&i.Author,
+		&i.PublicKey,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.LastIp,
+	)
+	return i, err
+}
+
+const createModAction = `-- name: CreateModAction :one
+INSERT INTO mod_action (moderator, action, target_type, target_id, reason, before, after)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, moderator, action, target_type, target_id, reason, before, after, created_at
+`
+
+type CreateModActionParams struct {
+	Moderator  uuid.UUID       `json:"moderator"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"targetType"`
+	TargetID   string          `json:"targetId"`
+	Reason     pgtype.Text     `json:"reason"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+}
+
+func (q *Queries) CreateModAction(ctx context.Context, arg CreateModActionParams) (ModAction, error) {
+	row := q.db.QueryRow(ctx, createModAction,
+		arg.Moderator,
+		arg.Action,
+		arg.TargetType,
+		arg.TargetID,
+		arg.Reason,
+		arg.Before,
+		arg.After,
+	)
+	var i ModAction
+	err := row.Scan(
+		&i.ID,
+		&i.Moderator,
+		&i.Action,
+		&i.TargetType,
+		&i.TargetID,
+		&i.Reason,
+		&i.Before,
+		&i.After,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const createPoll = `-- name: CreatePoll :one
+INSERT INTO poll (post, question, options, multiple, closes_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING post, question, options, multiple, closes_at, created_at
+`
+
+type CreatePollParams struct {
+	Post     int32              `json:"post"`
+	Question string             `json:"question"`
+	Options  []string           `json:"options"`
+	Multiple bool               `json:"multiple"`
+	ClosesAt pgtype.Timestamptz `json:"closesAt"`
+}
+
+func (q *Queries) CreatePoll(ctx context.Context, arg CreatePollParams) (Poll, error) {
+	row := q.db.QueryRow(ctx, createPoll,
+		arg.Post,
+		arg.Question,
+		arg.Options,
+		arg.Multiple,
+		arg.ClosesAt,
+	)
+	var i Poll
+	err := row.Scan(
+		&i.Post,
+		&i.Question,
+		&i.Options,
+		&i.Multiple,
+		&i.ClosesAt,
+		&i.CreatedAt,
 	)
 	return i, err
 }
 
 const createPost = `-- name: CreatePost :one
-INSERT INTO post (author, content)
-VALUES ($1, $2)
-RETURNING id, author, created_at, content
+INSERT INTO post (author, content, name, tripcode, visibility, fingerprint, board, content_html, attachment)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id, author, created_at, content, name, tripcode, deleted_at, deleted_by, visibility, locked_at, locked_by, archived_at, pinned_at, pinned_by, pinned_until, pin_order, fingerprint, board, content_html, attachment, edited_at
 `
 
 type CreatePostParams struct {
-	Author  uuid.UUID `json:"author"`
-	Content string    `json:"content"`
+	Author      uuid.UUID   `json:"author"`
+	Content     string      `json:"content"`
+	Name        pgtype.Text `json:"name"`
+	Tripcode    pgtype.Text `json:"tripcode"`
+	Visibility  string      `json:"visibility"`
+	Fingerprint pgtype.Int8 `json:"fingerprint"`
+	Board       int32       `json:"board"`
+	ContentHtml string      `json:"contentHtml"`
+	Attachment  pgtype.Text `json:"attachment"`
 }
 
 func (q *Queries) CreatePost(ctx context.Context, arg CreatePostParams) (Post, error) {
-	row := q.db.QueryRow(ctx, createPost, arg.Author, arg.Content)
+	row := q.db.QueryRow(ctx, createPost,
+		arg.Author,
+		arg.Content,
+		arg.Name,
+		arg.Tripcode,
+		arg.Visibility,
+		arg.Fingerprint,
+		arg.Board,
+		arg.ContentHtml,
+		arg.Attachment,
+	)
 	var i Post
 	err := row.Scan(
 		&i.ID,
 		&i.Author,
 		&i.CreatedAt,
 		&i.Content,
+		&i.Name,
+		&i.Tripcode,
+		&i.DeletedAt,
+		&i.DeletedBy,
+		&i.Visibility,
+		&i.LockedAt,
+		&i.LockedBy,
+		&i.ArchivedAt,
+		&i.PinnedAt,
+		&i.PinnedBy,
+		&i.PinnedUntil,
+		&i.PinOrder,
+		&i.Fingerprint,
+		&i.Board,
+		&i.ContentHtml,
+		&i.Attachment,
+		&i.EditedAt,
 	)
 	return i, err
 }
 
-const deleteComment = `-- name: DeleteComment :exec
-DELETE FROM comment
-WHERE id = $1
+const createPostRevision = `-- name: CreatePostRevision :one
+INSERT INTO post_revision (post, content, content_html, created_at)
+SELECT post.id, post.content, post.content_html, coalesce(post.edited_at, post.created_at) FROM post
+WHERE post.id = $1
+FOR UPDATE
+RETURNING id, post, content, content_html, created_at, replaced_at
 `
 
-func (q *Queries) DeleteComment(ctx context.Context, id int32) error {
-	_, err := q.db.Exec(ctx, deleteComment, id)
-	return err
+// Locks the post until the transaction ends, so concurrent edits keep every version
+func (q *Queries) CreatePostRevision(ctx context.Context, post int32) (PostRevision, error) {
+	row := q.db.QueryRow(ctx, createPostRevision, post)
+	var i PostRevision
+	err := row.Scan(
+		&i.ID,
+		&i.Post,
+		&i.Content,
+		&i.ContentHtml,
+		&i.CreatedAt,
+		&i.ReplacedAt,
+	)
+	return i, err
 }
 
-const deletePost = `-- name: DeletePost :exec
-DELETE FROM post
-WHERE id = $1
+const createRefreshToken = `-- name: CreateRefreshToken :one
+INSERT INTO refresh_token (family, author, token_hash, expires_at)
+VALUES ($1, $2, $3, $4)
+RETURNING id, family, author, token_hash, created_at, expires_at, used_at, revoked_at
 `
 
-func (q *Queries) DeletePost(ctx context.Context, id int32) error {
-	_, err := q.db.Exec(ctx, deletePost, id)
-	return err
+type CreateRefreshTokenParams struct {
+	Family    uuid.UUID          `json:"family"`
+	Author    uuid.UUID          `json:"author"`
+	TokenHash []byte             `json:"tokenHash"`
+	ExpiresAt pgtype.Timestamptz `json:"expiresAt"`
 }
 
-const getComment = `-- name: GetComment :one
-SELECT 
-    comment.id,
-    comment.author,
-    comment.content,
-    reply_comment.id as "reply_comment_id",
-    reply_comment.author as "reply_comment_author",
-    comment.created_at,
-    coalesce(likes.count, 0) as "likes_count",
-    CASE WHEN mine_like.id IS NOT NULL THEN true ELSE false END as "is_liked"
-FROM comment
-LEFT JOIN (
-    SELECT count(comment_like.comment) as "count", comment_like.comment as "comment_id" FROM comment_like
-    GROUP BY comment_like.comment
-) as likes
-ON likes.comment_id = comment.id
-LEFT JOIN (
-    SELECT comment_like.comment as "id" FROM comment_like 
-    WHERE comment_like.author = $2
-) as mine_like 
-ON mine_like.id = comment.id
-LEFT JOIN comment as reply_comment 
-ON reply_comment.id = comment.reply
-WHERE comment.id = $1
-`
-
-type GetCommentParams struct {
-	ID     int32     `json:"id"`
-	Author uuid.UUID `json:"author"`
+func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
+	row := q.db.QueryRow(ctx, createRefreshToken,
+		arg.Family,
+		arg.Author,
+		arg.TokenHash,
+		arg.ExpiresAt,
+	)
+	var i RefreshToken
+	err := row.Scan(
+		&i.ID,
+		&i.Family,
+		&i.Author,
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.UsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
 }
 
-type GetCommentRow struct {
-	ID                 int32              `json:"id"`
-	Author             uuid.UUID          `json:"author"`
-	Content            string             `json:"content"`
-	ReplyCommentID     pgtype.Int4        `json:"replyCommentId"`
-	ReplyCommentAuthor pgtype.UUID        `json:"replyCommentAuthor"`
-	CreatedAt          pgtype.Timestamptz `json:"createdAt"`
-	LikesCount         int64              `json:"likesCount"`
-	IsLiked            bool               `json:"isLiked"`
+const createReport = `-- name: CreateReport :one
+INSERT INTO report (author, post, comment, reason, details)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT DO NOTHING
+RETURNING id, author, post, comment, reason, details, created_at, resolved_at, resolved_by, resolution
+`
+
+type CreateReportParams struct {
+	Author  uuid.UUID   `json:"author"`
+	Post    pgtype.Int4 `json:"post"`
+	Comment pgtype.Int4 `json:"comment"`
+	Reason  string      `json:"reason"`
+	Details pgtype.Text `json:"details"`
 }
 
-func (q *Queries) GetComment(ctx context.Context, arg GetCommentParams) (GetCommentRow, error) {
-	row := q.db.QueryRow(ctx, getComment, arg.ID, arg.Author)
-	var i GetCommentRow
+func (q *Queries) CreateReport(ctx context.Context, arg CreateReportParams) (Report, error) {
+	row := q.db.QueryRow(ctx, createReport,
+		arg.Author,
+		arg.Post,
+		arg.Comment,
+		arg.Reason,
+		arg.Details,
+	)
+	var i Report
 	err := row.Scan(
 		&i.ID,
 		&i.Author,
-		&i.Content,
-		&i.ReplyCommentID,
-		&i.ReplyCommentAuthor,
+		&i.Post,
+		&i.Comment,
+		&i.Reason,
+		&i.Details,
 		&i.CreatedAt,
-		&i.LikesCount,
-		&i.IsLiked,
+		&i.ResolvedAt,
+		&i.ResolvedBy,
+		&i.Resolution,
 	)
 	return i, err
 }
 
-const getCommentAuthor = `-- name: GetCommentAuthor :one
-SELECT author from comment 
-WHERE id = $1
+const deleteComment = `-- name: DeleteComment :execrows
+UPDATE comment
+SET deleted_at = now(), deleted_by = $2
+WHERE id = $1 AND deleted_at IS NULL
 `
 
-func (q *Queries) GetCommentAuthor(ctx context.Context, id int32) (uuid.UUID, error) {
-	row := q.db.QueryRow(ctx, getCommentAuthor, id)
-	var author uuid.UUID
-	err := row.Scan(&author)
-	return author, err
+type DeleteCommentParams struct {
+	ID        int32       `json:"id"`
+	DeletedBy pgtype.UUID `json:"deletedBy"`
 }
 
-const getComments = `-- name: GetComments :many
-SELECT 
-    comment.id,
-    comment.author,
-    comment.content,
-    reply_comment.id as "reply_comment_id",
-    reply_comment.author as "reply_comment_author",
-    comment.created_at,
-    coalesce(likes.count, 0) as "likes_count",
-    CASE WHEN mine_like.id IS NOT NULL THEN true ELSE false END as "is_liked"
-FROM comment
-LEFT JOIN (
-    SELECT count(comment_like.comment) as "count", comment_like.comment as "comment_id" FROM comment_like
-    GROUP BY comment_like.comment
-) as likes
-ON likes.comment_id = comment.id
-LEFT JOIN (
-    SELECT comment_like.comment as "id" FROM comment_like 
-    WHERE comment_like.author = $2
-) as mine_like 
-ON mine_like.id = comment.id
-LEFT JOIN comment as reply_comment 
-ON reply_comment.id = comment.reply
-WHERE comment.post = $1 AND CASE WHEN $5::text != '' THEN 
-    CASE WHEN LEFT($5::text, 1) = '@' THEN
-        comment.author::text ILIKE concat('%', SUBSTRING($5::text, 2), '%') 
-    ELSE 
-        comment.content ILIKE concat('%', $5::text, '%') 
-    END
-ELSE true END
-ORDER BY 
-      CASE WHEN $6::bool THEN comment.created_at END ASC,
-      CASE WHEN $7::bool THEN comment.created_at END DESC,
-      CASE WHEN $8::bool THEN likes.count END ASC
-LIMIT $3 OFFSET $4
+func (q *Queries) DeleteComment(ctx context.Context, arg DeleteCommentParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deleteComment, arg.ID, arg.DeletedBy)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const deleteCommentRefs = `-- name: DeleteCommentRefs :exec
+DELETE FROM comment_ref
+WHERE comment = $1
 `
 
-type GetCommentsParams struct {
-	Post     int32     `json:"post"`
-	Author   uuid.UUID `json:"author"`
-	Limit    int32     `json:"limit"`
-	Offset   int32     `json:"offset"`
-	Search   string    `json:"search"`
-	DateAsc  bool      `json:"dateAsc"`
-	DateDesc bool      `json:"dateDesc"`
-	TopAsc   bool      `json:"topAsc"`
+func (q *Queries) DeleteCommentRefs(ctx context.Context, comment int32) error {
+	_, err := q.db.Exec(ctx, deleteCommentRefs, comment)
+	return err
 }
 
-type GetCommentsRow struct {
-	ID                 int32              `json:"id"`
-	Author             uuid.UUID          `json:"author"`
-	Content            string             `json:"content"`
-	ReplyCommentID     pgtype.Int4        `json:"replyCommentId"`
-	ReplyCommentAuthor pgtype.UUID        `json:"replyCommentAuthor"`
-	CreatedAt          pgtype.Timestamptz `json:"createdAt"`
-	LikesCount         int64              `json:"likesCount"`
-	IsLiked            bool               `json:"isLiked"`
+const deleteExpiredRevokedFamilies = `-- name: DeleteExpiredRevokedFamilies :exec
+DELETE FROM revoked_family
+WHERE expires_at <= now()
+`
+
+func (q *Queries) DeleteExpiredRevokedFamilies(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredRevokedFamilies)
+	return err
 }
 
-func (q *Queries) GetComments(ctx context.Context, arg GetCommentsParams) ([]GetCommentsRow, error) {
+const deleteExpiredRevokedTokens = `-- name: DeleteExpiredRevokedTokens :exec
+DELETE FROM revoked_token
+WHERE expires_at <= now()
+`
+
+func (q *Queries) DeleteExpiredRevokedTokens(ctx context.Context) error {
+	_, err := q.db.Exec(ctx, deleteExpiredRevokedTokens)
+	return err
+}
+
+const deletePost = `-- name: DeletePost :execrows
+UPDATE post
+SET deleted_at = now(), deleted_by = $2
+WHERE id = $1 AND deleted_at IS NULL
+`
+
+type DeletePostParams struct {
+	ID        int32       `json:"id"`
+	DeletedBy pgtype.UUID `json:"deletedBy"`
+}
+
+func (q *Queries) DeletePost(ctx context.Context, arg DeletePostParams) (int64, error) {
+	result, err := q.db.Exec(ctx, deletePost, arg.ID, arg.DeletedBy)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const editComment = `-- name: EditComment :one
+UPDATE comment SET
+    content = $1,
+    content_html = $2,
+    fingerprint = $3,
+    visibility = CASE WHEN comment.visibility = 'visible' THEN $4::text ELSE comment.visibility END,
+    edited_at = now()
+FROM post
+WHERE comment.id = $5 AND comment.author = $6 AND comment.deleted_at IS NULL
+    AND comment.created_at > $7::timestamptz
+    AND post.id = comment.post AND post.deleted_at IS NULL AND post.locked_at IS NULL AND post.archived_at IS NULL
+RETURNING comment.id, comment.post, comment.author, comment.reply, comment.content, comment.created_at, comment.name, comment.tripcode, comment.deleted_at, comment.deleted_by, comment.visibility, comment.fingerprint, comment.content_html, comment.attachment, comment.edited_at
+`
+
+type EditCommentParams struct {
+	Content       string             `json:"content"`
+	ContentHtml   string             `json:"contentHtml"`
+	Fingerprint   pgtype.Int8        `json:"fingerprint"`
+	Visibility    string             `json:"visibility"`
+	ID            int32              `json:"id"`
+	Author        uuid.UUID          `json:"author"`
+	EditableSince pgtype.Timestamptz `json:"editableSince"`
+}
+
+// Comments in locked or archived threads can't be edited
+func (q *Queries) EditComment(ctx context.Context, arg EditCommentParams) (Comment, error) {
+	row := q.db.QueryRow(ctx, editComment,
+		arg.Content,
+		arg.ContentHtml,
+		arg.Fingerprint,
+		arg.Visibility,
+		arg.ID,
+		arg.Author,
+		arg.EditableSince,
+	)
+	var i Comment
+	err := row.Scan(
+		&i.ID,
+		&i.Post,
+		&i.Author,
+		&i.Reply,
+		&i.Content,
+		&i.CreatedAt,
+		&i.Name,
+		&i.Tripcode,
+		&i.DeletedAt,
+		&i.DeletedBy,
+		&i.Visibility,
+		&i.Fingerprint,
+		&i.ContentHtml,
+		&i.Attachment,
+		&i.EditedAt,
+	)
+	return i, err
+}
+
+const editPost = `-- name: EditPost :one
+UPDATE post SET
+    content = $1,
+    content_html = $2,
+    fingerprint = $3,
+    visibility = CASE WHEN post.visibility = 'visible' THEN $4::text ELSE post.visibility END,
+    edited_at = now()
+WHERE post.id = $5 AND post.author = $6 AND post.deleted_at IS NULL AND post.archived_at IS NULL
+    AND post.created_at > $7::timestamptz
+RETURNING id, author, created_at, content, name, tripcode, deleted_at, deleted_by, visibility, locked_at, locked_by, archived_at, pinned_at, pinned_by, pinned_until, pin_order, fingerprint, board, content_html, attachment, edited_at
+`
+
+type EditPostParams struct {
+	Content       string             `json:"content"`
+	ContentHtml   string             `json:"contentHtml"`
+	Fingerprint   pgtype.Int8        `json:"fingerprint"`
+	Visibility    string             `json:"visibility"`
+	ID            int32              `json:"id"`
+	Author        uuid.UUID          `json:"author"`
+	EditableSince pgtype.Timestamptz `json:"editableSince"`
+}
+
+// Held and shadowed posts stay so after an edit
+func (q *Queries) EditPost(ctx context.Context, arg EditPostParams) (Post, error) {
+	row := q.db.QueryRow(ctx, editPost,
+		arg.Content,
+		arg.ContentHtml,
+		arg.Fingerprint,
+		arg.Visibility,
+		arg.ID,
+		arg.Author,
+		arg.EditableSince,
+	)
+	var i Post
+	err := row.Scan(
+		&i.ID,
+		&i.Author,
+		&i.CreatedAt,
+		&i.Content,
+		&i.Name,
+		&i.Tripcode,
+		&i.DeletedAt,
+		&i.DeletedBy,
+		&i.Visibility,
+		&i.LockedAt,
+		&i.LockedBy,
+		&i.ArchivedAt,
+		&i.PinnedAt,
+		&i.PinnedBy,
+		&i.PinnedUntil,
+		&i.PinOrder,
+		&i.Fingerprint,
+		&i.Board,
+		&i.ContentHtml,
+		&i.Attachment,
+		&i.EditedAt,
+	)
+	return i, err
+}
+
+const getActiveBan = `-- name: GetActiveBan :one
+SELECT id, author, network, reason, expires_at, created_by, created_at, lifted_at, lifted_by FROM ban
+WHERE lifted_at IS NULL 
+    AND (expires_at IS NULL OR expires_at > now())
+    AND (author = $1 OR network >>= $2::inet)
+ORDER BY expires_at DESC NULLS FIRST
+LIMIT 1
+`
+
+type GetActiveBanParams struct {
+	Author pgtype.UUID `json:"author"`
+	Ip     netip.Addr  `json:"ip"`
+}
+
+func (q *Queries) GetActiveBan(ctx context.Context, arg GetActiveBanParams) (Ban, error) {
+	row := q.db.QueryRow(ctx, getActiveBan, arg.Author, arg.Ip)
+	var i Ban
+	err := row.Scan(
+		&i.ID,
+		&i.Author,
+		&i.Network,
+		&i.Reason,
+		&i.ExpiresAt,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.LiftedAt,
+		&i.LiftedBy,
+	)
+	return i, err
+}
+
+const getAttachment = `-- name: GetAttachment :one
+SELECT hash, content_type, size, width, height, file_key, thumb_key, thumb_width, thumb_height, created_at FROM attachment
+WHERE hash = $1
+`
+
+func (q *Queries) GetAttachment(ctx context.Context, hash string) (Attachment, error) {
+	row := q.db.QueryRow(ctx, getAttachment, hash)
+	var i Attachment
+	err := row.Scan(
+		&i.Hash,
+		&i.ContentType,
+		&i.Size,
+		&i.Width,
+		&i.Height,
+		&i.FileKey,
+		&i.ThumbKey,
+		&i.ThumbWidth,
+		&i.ThumbHeight,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getAuthor = `-- name: GetAuthor :one
+SELECT id, ip, role, shadowbanned FROM author
+WHERE id = $1
+`
+
+func (q *Queries) GetAuthor(ctx context.Context, id uuid.UUID) (Author, error) {
+	row := q.db.QueryRow(ctx, getAuthor, id)
+	var i Author
+	err := row.Scan(
+		&i.ID,
+		&i.Ip,
+		&i.Role,
+		&i.Shadowbanned,
+	)
+	return i, err
+}
+
+const getBan = `-- name: GetBan :one
+SELECT id, author, network, reason, expires_at, created_by, created_at, lifted_at, lifted_by FROM ban
+WHERE id = $1
+`
+
+func (q *Queries) GetBan(ctx context.Context, id int32) (Ban, error) {
+	row := q.db.QueryRow(ctx, getBan, id)
+	var i Ban
+	err := row.Scan(
+		&i.ID,
+		&i.Author,
+		&i.Network,
+		&i.Reason,
+		&i.ExpiresAt,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.LiftedAt,
+		&i.LiftedBy,
+	)
+	return i, err
+}
+
+const getBans = `-- name: GetBans :many
+SELECT id, author, network, reason, expires_at, created_by, created_at, lifted_at, lifted_by FROM ban
+WHERE CASE WHEN $3::bool THEN 
+    lifted_at IS NULL AND (expires_at IS NULL OR expires_at > now())
+ELSE true END
+ORDER BY created_at DESC
+LIMIT $1 OFFSET $2
+`
+
+type GetBansParams struct {
+	Limit  int32 `json:"limit"`
+	Offset int32 `json:"offset"`
+	Active bool  `json:"active"`
+}
+
+func (q *Queries) GetBans(ctx context.Context, arg GetBansParams) ([]Ban, error) {
+	rows, err := q.db.Query(ctx, getBans, arg.Limit, arg.Offset, arg.Active)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Ban
+	for rows.Next() {
+		var i Ban
+		if err := rows.Scan(
+			&i.ID,
+			&i.Author,
+			&i.Network,
+			&i.Reason,
+			&i.ExpiresAt,
+			&i.CreatedBy,
+			&i.CreatedAt,
+			&i.LiftedAt,
+			&i.LiftedBy,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getBoard = `-- name: GetBoard :one
+SELECT id, slug, title, description, settings, created_at FROM board
+WHERE slug = $1
+`
+
+func (q *Queries) GetBoard(ctx context.Context, slug string) (Board, error) {
+	row := q.db.QueryRow(ctx, getBoard, slug)
+	var i Board
+	err := row.Scan(
+		&i.ID,
+		&i.Slug,
+		&i.Title,
+		&i.Description,
+		&i.Settings,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const getBoards = `-- name: GetBoards :many
+SELECT id, slug, title, description, settings, created_at FROM board
+ORDER BY slug
+`
+
+func (q *Queries) GetBoards(ctx context.Context) ([]Board, error) {
+	rows, err := q.db.Query(ctx, getBoards)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Board
+	for rows.Next() {
+		var i Board
+		if err := rows.Scan(
+			&i.ID,
+			&i.Slug,
+			&i.Title,
+			&i.Description,
+			&i.Settings,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getComment = `-- name: GetComment :one
+SELECT 
+    comment.id,
+    comment.post,
+    comment.author,
+    comment.content,
+    comment.content_html,
+    attachment_json(attachment) as "attachment",
+    comment_quotes(comment.id) as "quotes",
+    comment_backlinks(comment.id, $2) as "backlinks",
+    comment.name,
+    comment.tripcode,
+    reply_comment.id as "reply_comment_id",
+    reply_comment.author as "reply_comment_author",
+    reply_comment.deleted_at IS NOT NULL as "reply_comment_deleted",
+    comment.created_at,
+    comment.deleted_at,
+    comment.edited_at,
+    coalesce(likes.count, 0) as "likes_count",
+    CASE WHEN mine_like.id IS NOT NULL THEN true ELSE false END as "is_liked"
+FROM comment
+LEFT JOIN (
+    SELECT count(comment_like.comment) as "count", comment_like.comment as "comment_id" FROM comment_like
+    JOIN author ON author.id = comment_like.author
+    WHERE NOT author.shadowbanned OR author.id = $2
+    GROUP BY comment_like.comment
+) as likes
+ON likes.comment_id = comment.id
+LEFT JOIN (
+    SELECT comment_like.comment as "id" FROM comment_like 
+    WHERE comment_like.author = $2
+) as mine_like 
+ON mine_like.id = comment.id
+LEFT JOIN comment as reply_comment 
+ON reply_comment.id = comment.reply
+JOIN author as comment_author
+ON comment_author.id = comment.author
+LEFT JOIN attachment
+ON attachment.hash = comment.attachment
+WHERE comment.id = $1 
+    AND (comment.author = $2 OR (comment.visibility = 'visible' AND NOT comment_author.shadowbanned))
+`
+
+type GetCommentParams struct {
+	ID     int32     `json:"id"`
+	Author uuid.UUID `json:"author"`
+}
+
+type GetCommentRow struct {
+	ID                  int32              `json:"id"`
+	Post                int32              `json:"post"`
+	Author              uuid.UUID          `json:"author"`
+	Content             string             `json:"content"`
+	ContentHtml         string             `json:"contentHtml"`
+	Attachment          json.RawMessage    `json:"attachment"`
+	Quotes              json.RawMessage    `json:"quotes"`
+	Backlinks           json.RawMessage    `json:"backlinks"`
+	Name                pgtype.Text        `json:"name"`
+	Tripcode            pgtype.Text        `json:"tripcode"`
+	ReplyCommentID      pgtype.Int4        `json:"replyCommentId"`
+	ReplyCommentAuthor  pgtype.UUID        `json:"replyCommentAuthor"`
+	ReplyCommentDeleted bool               `json:"replyCommentDeleted"`
+	CreatedAt           pgtype.Timestamptz `json:"createdAt"`
+	DeletedAt           pgtype.Timestamptz `json:"deletedAt"`
+	EditedAt            pgtype.Timestamptz `json:"editedAt"`
+	LikesCount          int64              `json:"likesCount"`
+	IsLiked             bool               `json:"isLiked"`
+}
+
+func (q *Queries) GetComment(ctx context.Context, arg GetCommentParams) (GetCommentRow, error) {
+	row := q.db.QueryRow(ctx, getComment, arg.ID, arg.Author)
+	var i GetCommentRow
+	err := row.Scan(
+		&i.ID,
+		&i.Post,
+		&i.Author,
+		&i.Content,
+		&i.ContentHtml,
+		&i.Attachment,
+		&i.Quotes,
+		&i.Backlinks,
+		&i.Name,
+		&i.Tripcode,
+		&i.ReplyCommentID,
+		&i.ReplyCommentAuthor,
+		&i.ReplyCommentDeleted,
+		&i.CreatedAt,
+		&i.DeletedAt,
+		&i.EditedAt,
+		&i.LikesCount,
+		&i.IsLiked,
+	)
+	return i, err
+}
+
+const getCommentAuthor = `-- name: GetCommentAuthor :one
+SELECT author from comment 
+WHERE id = $1
+`
+
+func (q *Queries) GetCommentAuthor(ctx context.Context, id int32) (uuid.UUID, error) {
+	row := q.db.QueryRow(ctx, getCommentAuthor, id)
+	var author uuid.UUID
+	err := row.Scan(&author)
+	return author, err
+}
+
+const getCommentById = `-- name: GetCommentById :one
+SELECT id, post, author, reply, content, created_at, name, tripcode, deleted_at, deleted_by, visibility, fingerprint, content_html, attachment, edited_at FROM comment
+WHERE id = $1
+`
+
+func (q *Queries) GetCommentById(ctx context.Context, id int32) (Comment, error) {
+	row := q.db.QueryRow(ctx, getCommentById, id)
+	var i Comment
+	err := row.Scan(
+		&i.ID,
+		&i.Post,
+		&i.Author,
+		&i.Reply,
+		&i.Content,
+		&i.CreatedAt,
+		&i.Name,
+		&i.Tripcode,
+		&i.DeletedAt,
+		&i.DeletedBy,
+		&i.Visibility,
+		&i.Fingerprint,
+		&i.ContentHtml,
+		&i.Attachment,
+		&i.EditedAt,
+	)
+	return i, err
+}
+
+const getCommentRevisions = `-- name: GetCommentRevisions :many
+SELECT comment_revision.id, comment_revision.comment, comment_revision.content, comment_revision.content_html, comment_revision.created_at, comment_revision.replaced_at FROM comment_revision
+JOIN comment ON comment.id = comment_revision.comment
+JOIN author ON author.id = comment.author
+WHERE comment_revision.comment = $1 AND comment.deleted_at IS NULL
+    AND (comment.author = $2 OR (comment.visibility = 'visible' AND NOT author.shadowbanned))
+ORDER BY comment_revision.id DESC
+`
+
+type GetCommentRevisionsParams struct {
+	Comment int32     `json:"comment"`
+	Author  uuid.UUID `json:"author"`
+}
+
+func (q *Queries) GetCommentRevisions(ctx context.Context, arg GetCommentRevisionsParams) ([]CommentRevision, error) {
+	rows, err := q.db.Query(ctx, getCommentRevisions, arg.Comment, arg.Author)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CommentRevision
+	for rows.Next() {
+		var i CommentRevision
+		if err := rows.Scan(
+			&i.ID,
+			&i.Comment,
+			&i.Content,
+			&i.ContentHtml,
+			&i.CreatedAt,
+			&i.ReplacedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getComments = `-- name: GetComments :many
+SELECT 
+    comment.id,
+    comment.author,
+    comment.content,
+    comment.content_html,
+    attachment_json(attachment) as "attachment",
+    comment_quotes(comment.id) as "quotes",
+    comment_backlinks(comment.id, $2) as "backlinks",
+    comment.name,
+    comment.tripcode,
+    reply_comment.id as "reply_comment_id",
+    reply_comment.author as "reply_comment_author",
+    reply_comment.deleted_at IS NOT NULL as "reply_comment_deleted",
+    comment.created_at,
+    comment.deleted_at,
+    comment.edited_at,
+    coalesce(likes.count, 0) as "likes_count",
+    CASE WHEN mine_like.id IS NOT NULL THEN true ELSE false END as "is_liked"
+FROM comment
+LEFT JOIN (
+    SELECT count(comment_like.comment) as "count", comment_like.comment as "comment_id" FROM comment_like
+    JOIN author ON author.id = comment_like.author
+    WHERE NOT author.shadowbanned OR author.id = $2
+    GROUP BY comment_like.comment
+) as likes
+ON likes.comment_id = comment.id
+LEFT JOIN (
+    SELECT comment_like.comment as "id" FROM comment_like 
+    WHERE comment_like.author = $2
+) as mine_like 
+ON mine_like.id = comment.id
+LEFT JOIN comment as reply_comment 
+ON reply_comment.id = comment.reply
+JOIN author as comment_author
+ON comment_author.id = comment.author
+LEFT JOIN attachment
+ON attachment.hash = comment.attachment
+WHERE comment.post = $1 
+    AND (comment.author = $2 OR (comment.visibility = 'visible' AND NOT comment_author.shadowbanned))
+    AND CASE WHEN $5::text != '' THEN 
+    comment.deleted_at IS NULL AND CASE WHEN LEFT($5::text, 1) = '@' THEN
+        comment.author::text ILIKE concat('%', SUBSTRING($5::text, 2), '%') 
+    ELSE 
+        comment.content ILIKE concat('%', $5::text, '%') 
+    END
+ELSE true END
+ORDER BY 
+      CASE WHEN $6::bool THEN comment.created_at END ASC,
+      CASE WHEN $7::bool THEN comment.created_at END DESC,
+      CASE WHEN $8::bool THEN likes.count END ASC
+LIMIT $3 OFFSET $4
+`
+
+type GetCommentsParams struct {
+	Post     int32     `json:"post"`
+	Author   uuid.UUID `json:"author"`
+	Limit    int32     `json:"limit"`
+	Offset   int32     `json:"offset"`
+	Search   string    `json:"search"`
+	DateAsc  bool      `json:"dateAsc"`
+	DateDesc bool      `json:"dateDesc"`
+	TopAsc   bool      `json:"topAsc"`
+}
+
+type GetCommentsRow struct {
+	ID                  int32              `json:"id"`
+	Author              uuid.UUID          `json:"author"`
+	Content             string             `json:"content"`
+	ContentHtml         string             `json:"contentHtml"`
+	Attachment          json.RawMessage    `json:"attachment"`
+	Quotes              json.RawMessage    `json:"quotes"`
+	Backlinks           json.RawMessage    `json:"backlinks"`
+	Name                pgtype.Text        `json:"name"`
+	Tripcode            pgtype.Text        `json:"tripcode"`
+	ReplyCommentID      pgtype.Int4        `json:"replyCommentId"`
+	ReplyCommentAuthor  pgtype.UUID        `json:"replyCommentAuthor"`
+	ReplyCommentDeleted bool               `json:"replyCommentDeleted"`
+	CreatedAt           pgtype.Timestamptz `json:"createdAt"`
+	DeletedAt           pgtype.Timestamptz `json:"deletedAt"`
+	EditedAt            pgtype.Timestamptz `json:"editedAt"`
+	LikesCount          int64              `json:"likesCount"`
+	IsLiked             bool               `json:"isLiked"`
+}
+
+func (q *Queries) GetComments(ctx context.Context, arg GetCommentsParams) ([]GetCommentsRow, error) {
 	rows, err := q.db.Query(ctx, getComments,
 		arg.Post,
 		arg.Author,
 		arg.Limit,
 		arg.Offset,
-		arg.Search,
-		arg.DateAsc,
-		arg.DateDesc,
-		arg.TopAsc,
+		arg.Search,
+		arg.DateAsc,
+		arg.DateDesc,
+		arg.TopAsc,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetCommentsRow
+	for rows.Next() {
+		var i GetCommentsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Author,
+			&i.Content,
+			&i.ContentHtml,
+			&i.Attachment,
+			&i.Quotes,
+			&i.Backlinks,
+			&i.Name,
+			&i.Tripcode,
+			&i.ReplyCommentID,
+			&i.ReplyCommentAuthor,
+			&i.ReplyCommentDeleted,
+			&i.CreatedAt,
+			&i.DeletedAt,
+			&i.EditedAt,
+			&i.LikesCount,
+			&i.IsLiked,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getDeviceKey = `-- name: GetDeviceKey :one
+SELECT id, author, public_key, created_at, last_used_at, last_ip FROM device_key
+WHERE public_key = $1
+`
+
+func (q *Queries) GetDeviceKey(ctx context.Context, publicKey []byte) (DeviceKey, error) {
+	row := q.db.QueryRow(ctx, getDeviceKey, publicKey)
+	var i DeviceKey
+	err := row.Scan(
+		&i.ID,
+		&i.Author,
+		&i.PublicKey,
+		&i.CreatedAt,
+		&i.LastUsedAt,
+		&i.LastIp,
+	)
+	return i, err
+}
+
+const getFilterRules = `-- name: GetFilterRules :many
+SELECT id, kind, pattern, threshold, action, message, replacement, dry_run, enabled FROM filter_rule
+WHERE enabled
+ORDER BY id
+`
+
+func (q *Queries) GetFilterRules(ctx context.Context) ([]FilterRule, error) {
+	rows, err := q.db.Query(ctx, getFilterRules)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []FilterRule
+	for rows.Next() {
+		var i FilterRule
+		if err := rows.Scan(
+			&i.ID,
+			&i.Kind,
+			&i.Pattern,
+			&i.Threshold,
+			&i.Action,
+			&i.Message,
+			&i.Replacement,
+			&i.DryRun,
+			&i.Enabled,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getHeldComments = `-- name: GetHeldComments :many
+SELECT id, post, author, reply, content, created_at, name, tripcode, deleted_at, deleted_by, visibility, fingerprint, content_html, attachment, edited_at FROM comment
+WHERE visibility = 'held' AND deleted_at IS NULL
+ORDER BY created_at
+LIMIT $1 OFFSET $2
+`
+
+type GetHeldCommentsParams struct {
+	Limit  int32 `json:"limit"`
+	Offset int32 `json:"offset"`
+}
+
+func (q *Queries) GetHeldComments(ctx context.Context, arg GetHeldCommentsParams) ([]Comment, error) {
+	rows, err := q.db.Query(ctx, getHeldComments, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Comment
+	for rows.Next() {
+		var i Comment
+		if err := rows.Scan(
+			&i.ID,
+			&i.Post,
+			&i.Author,
+			&i.Reply,
+			&i.Content,
+			&i.CreatedAt,
+			&i.Name,
+			&i.Tripcode,
+			&i.DeletedAt,
+			&i.DeletedBy,
+			&i.Visibility,
+			&i.Fingerprint,
+			&i.ContentHtml,
+			&i.Attachment,
+			&i.EditedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getHeldPosts = `-- name: GetHeldPosts :many
+SELECT id, author, created_at, content, name, tripcode, deleted_at, deleted_by, visibility, locked_at, locked_by, archived_at, pinned_at, pinned_by, pinned_until, pin_order, fingerprint, board, content_html, attachment, edited_at FROM post
+WHERE visibility = 'held' AND deleted_at IS NULL
+ORDER BY created_at
+LIMIT $1 OFFSET $2
+`
+
+type GetHeldPostsParams struct {
+	Limit  int32 `json:"limit"`
+	Offset int32 `json:"offset"`
+}
+
+func (q *Queries) GetHeldPosts(ctx context.Context, arg GetHeldPostsParams) ([]Post, error) {
+	rows, err := q.db.Query(ctx, getHeldPosts, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Post
+	for rows.Next() {
+		var i Post
+		if err := rows.Scan(
+			&i.ID,
+			&i.Author,
+			&i.CreatedAt,
+			&i.Content,
+			&i.Name,
+			&i.Tripcode,
+			&i.DeletedAt,
+			&i.DeletedBy,
+			&i.Visibility,
+			&i.LockedAt,
+			&i.LockedBy,
+			&i.ArchivedAt,
+			&i.PinnedAt,
+			&i.PinnedBy,
+			&i.PinnedUntil,
+			&i.PinOrder,
+			&i.Fingerprint,
+			&i.Board,
+			&i.ContentHtml,
+			&i.Attachment,
+			&i.EditedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getModActions = `-- name: GetModActions :many
+SELECT id, moderator, action, target_type, target_id, reason, before, after, created_at FROM mod_action
+WHERE ($3::uuid IS NULL OR moderator = $3)
+    AND ($4::text IS NULL OR target_type = $4)
+    AND ($5::text IS NULL OR target_id = $5)
+    AND ($6::timestamptz IS NULL OR created_at >= $6)
+    AND ($7::timestamptz IS NULL OR created_at < $7)
+ORDER BY created_at DESC, id DESC
+LIMIT $1 OFFSET $2
+`
+
+type GetModActionsParams struct {
+	Limit         int32              `json:"limit"`
+	Offset        int32              `json:"offset"`
+	Moderator     pgtype.UUID        `json:"moderator"`
+	TargetType    pgtype.Text        `json:"targetType"`
+	TargetID      pgtype.Text        `json:"targetId"`
+	CreatedAfter  pgtype.Timestamptz `json:"createdAfter"`
+	CreatedBefore pgtype.Timestamptz `json:"createdBefore"`
+}
+
+func (q *Queries) GetModActions(ctx context.Context, arg GetModActionsParams) ([]ModAction, error) {
+	rows, err := q.db.Query(ctx, getModActions,
+		arg.Limit,
+		arg.Offset,
+		arg.Moderator,
+		arg.TargetType,
+		arg.TargetID,
+		arg.CreatedAfter,
+		arg.CreatedBefore,
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetCommentsRow
+	var items []ModAction
 	for rows.Next() {
-		var i GetCommentsRow
+		var i ModAction
 		if err := rows.Scan(
 			&i.ID,
+			&i.Moderator,
+			&i.Action,
+			&i.TargetType,
+			&i.TargetID,
+			&i.Reason,
+			&i.Before,
+			&i.After,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOpenReports = `-- name: GetOpenReports :many
+SELECT
+    reports.post,
+    reports.comment,
+    coalesce(post.author, comment.author)::uuid as "author",
+    coalesce(post.content, comment.content)::text as "content",
+    reports.count as "reports_count",
+    reports.reasons,
+    reports.first_reported_at,
+    reports.last_reported_at
+FROM (
+    SELECT
+        report.post,
+        report.comment,
+        count(report.id) as "count",
+        array_agg(DISTINCT report.reason)::text[] as "reasons",
+        min(report.created_at)::timestamptz as "first_reported_at",
+        max(report.created_at)::timestamptz as "last_reported_at"
+    FROM report
+    WHERE report.resolved_at IS NULL
+    GROUP BY report.post, report.comment
+) as reports
+LEFT JOIN post ON post.id = reports.post
+LEFT JOIN comment ON comment.id = reports.comment
+ORDER BY reports.count DESC, reports.first_reported_at ASC
+LIMIT $1 OFFSET $2
+`
+
+type GetOpenReportsParams struct {
+	Limit  int32 `json:"limit"`
+	Offset int32 `json:"offset"`
+}
+
+type GetOpenReportsRow struct {
+	Post            pgtype.Int4        `json:"post"`
+	Comment         pgtype.Int4        `json:"comment"`
+	Author          uuid.UUID          `json:"author"`
+	Content         string             `json:"content"`
+	ReportsCount    int64              `json:"reportsCount"`
+	Reasons         []string           `json:"reasons"`
+	FirstReportedAt pgtype.Timestamptz `json:"firstReportedAt"`
+	LastReportedAt  pgtype.Timestamptz `json:"lastReportedAt"`
+}
+
+func (q *Queries) GetOpenReports(ctx context.Context, arg GetOpenReportsParams) ([]GetOpenReportsRow, error) {
+	rows, err := q.db.Query(ctx, getOpenReports, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetOpenReportsRow
+	for rows.Next() {
+		var i GetOpenReportsRow
+		if err := rows.Scan(
+			&i.Post,
+			&i.Comment,
 			&i.Author,
 			&i.Content,
-			&i.ReplyCommentID,
-			&i.ReplyCommentAuthor,
-			&i.CreatedAt,
-			&i.LikesCount,
-			&i.IsLiked,
+			&i.ReportsCount,
+			&i.Reasons,
+			&i.FirstReportedAt,
+			&i.LastReportedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -297,6 +1567,60 @@ func (q *Queries) GetComments(ctx context.Context, arg GetCommentsParams) ([]Get
 	return items, nil
 }
 
+const getPoll = `-- name: GetPoll :one
+SELECT poll.post, poll.question, poll.options, poll.multiple, poll.closes_at, poll.created_at, post.author, post.visibility, post.deleted_at, post.archived_at FROM poll
+JOIN post ON post.id = poll.post
+WHERE poll.post = $1
+`
+
+type GetPollRow struct {
+	Post       int32              `json:"post"`
+	Question   string             `json:"question"`
+	Options    []string           `json:"options"`
+	Multiple   bool               `json:"multiple"`
+	ClosesAt   pgtype.Timestamptz `json:"closesAt"`
+	CreatedAt  pgtype.Timestamptz `json:"createdAt"`
+	Author     uuid.UUID          `json:"author"`
+	Visibility string             `json:"visibility"`
+	DeletedAt  pgtype.Timestamptz `json:"deletedAt"`
+	ArchivedAt pgtype.Timestamptz `json:"archivedAt"`
+}
+
+func (q *Queries) GetPoll(ctx context.Context, post int32) (GetPollRow, error) {
+	row := q.db.QueryRow(ctx, getPoll, post)
+	var i GetPollRow
+	err := row.Scan(
+		&i.Post,
+		&i.Question,
+		&i.Options,
+		&i.Multiple,
+		&i.ClosesAt,
+		&i.CreatedAt,
+		&i.Author,
+		&i.Visibility,
+		&i.DeletedAt,
+		&i.ArchivedAt,
+	)
+	return i, err
+}
+
+const getPollResults = `-- name: GetPollResults :one
+SELECT poll_json(poll, $1::uuid) FROM poll
+WHERE poll.post = $2
+`
+
+type GetPollResultsParams struct {
+	Viewer uuid.UUID `json:"viewer"`
+	Post   int32     `json:"post"`
+}
+
+func (q *Queries) GetPollResults(ctx context.Context, arg GetPollResultsParams) (json.RawMessage, error) {
+	row := q.db.QueryRow(ctx, getPollResults, arg.Viewer, arg.Post)
+	var poll_json json.RawMessage
+	err := row.Scan(&poll_json)
+	return poll_json, err
+}
+
 const getPostAuthor = `-- name: GetPostAuthor :one
 SELECT author from post
 WHERE id = $1
@@ -309,23 +1633,114 @@ func (q *Queries) GetPostAuthor(ctx context.Context, id int32) (uuid.UUID, error
 	return author, err
 }
 
+const getPostById = `-- name: GetPostById :one
+SELECT id, author, created_at, content, name, tripcode, deleted_at, deleted_by, visibility, locked_at, locked_by, archived_at, pinned_at, pinned_by, pinned_until, pin_order, fingerprint, board, content_html, attachment, edited_at FROM post
+WHERE id = $1
+`
+
+func (q *Queries) GetPostById(ctx context.Context, id int32) (Post, error) {
+	row := q.db.QueryRow(ctx, getPostById, id)
+	var i Post
+	err := row.Scan(
+		&i.ID,
+		&i.Author,
+		&i.CreatedAt,
+		&i.Content,
+		&i.Name,
+		&i.Tripcode,
+		&i.DeletedAt,
+		&i.DeletedBy,
+		&i.Visibility,
+		&i.LockedAt,
+		&i.LockedBy,
+		&i.ArchivedAt,
+		&i.PinnedAt,
+		&i.PinnedBy,
+		&i.PinnedUntil,
+		&i.PinOrder,
+		&i.Fingerprint,
+		&i.Board,
+		&i.ContentHtml,
+		&i.Attachment,
+		&i.EditedAt,
+	)
+	return i, err
+}
+
+const getPostRevisions = `-- name: GetPostRevisions :many
+SELECT post_revision.id, post_revision.post, post_revision.content, post_revision.content_html, post_revision.created_at, post_revision.replaced_at FROM post_revision
+JOIN post ON post.id = post_revision.post
+JOIN author ON author.id = post.author
+WHERE post_revision.post = $1 AND post.deleted_at IS NULL
+    AND (post.author = $2 OR (post.visibility = 'visible' AND NOT author.shadowbanned))
+ORDER BY post_revision.id DESC
+`
+
+type GetPostRevisionsParams struct {
+	Post   int32     `json:"post"`
+	Author uuid.UUID `json:"author"`
+}
+
+func (q *Queries) GetPostRevisions(ctx context.Context, arg GetPostRevisionsParams) ([]PostRevision, error) {
+	rows, err := q.db.Query(ctx, getPostRevisions, arg.Post, arg.Author)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PostRevision
+	for rows.Next() {
+		var i PostRevision
+		if err := rows.Scan(
+			&i.ID,
+			&i.Post,
+			&i.Content,
+			&i.ContentHtml,
+			&i.CreatedAt,
+			&i.ReplacedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getPosts = `-- name: GetPosts :many
 SELECT 
     post.id, 
     post.author, 
+    board.slug as "board",
     post.created_at, 
+    post.edited_at,
     post.content, 
+    post.content_html,
+    attachment_json(attachment) as "attachment",
+    poll_json(poll, $1) as "poll",
+    post.name,
+    post.tripcode,
+    post.locked_at,
+    post.archived_at,
+    post.pinned_until,
+    CASE WHEN post.pinned_at IS NOT NULL AND (post.pinned_until IS NULL OR post.pinned_until > now()) THEN true ELSE false END as "is_pinned",
     coalesce(likes.count, 0) as "likes_count",
     coalesce(comments.count, 0) as "comments_count",
     CASE WHEN mine_like.id IS NOT NULL THEN true ELSE false END as "is_liked"
 FROM post  
 LEFT JOIN (
     SELECT count(post_like.post) as "count", post_like.post as "post_id" FROM post_like
+    JOIN author ON author.id = post_like.author
+    WHERE NOT author.shadowbanned OR author.id = $1
     GROUP BY post_like.post
 ) as likes
 ON likes.post_id = post.id
 LEFT JOIN (
     SELECT count(comment.id) as "count", comment.post as "post_id" FROM comment 
+    JOIN author ON author.id = comment.author
+    WHERE comment.deleted_at IS NULL
+        AND (comment.author = $1 OR (comment.visibility = 'visible' AND NOT author.shadowbanned))
     GROUP BY comment.post
 ) as comments
 ON comments.post_id = post.id
@@ -334,66 +1749,255 @@ LEFT JOIN (
     WHERE post_like.author = $1
 ) as mine_like 
 ON mine_like.id = post.id
-WHERE CASE WHEN $4::text != '' THEN 
-    CASE WHEN LEFT($4::text, 1) = '@' THEN
-        post.author::text ILIKE concat('%', SUBSTRING($4::text, 2), '%') 
-    ELSE 
-        post.content ILIKE concat('%', $4::text, '%') 
-    END
-ELSE true END
+JOIN author as post_author
+ON post_author.id = post.author
+JOIN board
+ON board.id = post.board
+LEFT JOIN attachment
+ON attachment.hash = post.attachment
+LEFT JOIN poll
+ON poll.post = post.id
+WHERE post.deleted_at IS NULL 
+    AND (post.archived_at IS NOT NULL) = $4::bool
+    AND ($5::int IS NULL OR post.board = $5)
+    AND (post.author = $1 OR (post.visibility = 'visible' AND NOT post_author.shadowbanned))
+    AND (
+        (post.pinned_at IS NOT NULL AND (post.pinned_until IS NULL OR post.pinned_until > now()))
+        OR CASE WHEN $6::text != '' THEN 
+        CASE WHEN LEFT($6::text, 1) = '@' THEN
+            post.author::text ILIKE concat('%', SUBSTRING($6::text, 2), '%') 
+        ELSE 
+            post.content ILIKE concat('%', $6::text, '%') 
+        END
+    ELSE true END
+    )
 ORDER BY 
-      CASE WHEN $5::bool THEN post.created_at END ASC,
-      CASE WHEN $6::bool THEN post.created_at END DESC,
-      CASE WHEN $7::bool THEN likes.count END ASC
+      "is_pinned" DESC,
+      CASE WHEN post.pinned_at IS NOT NULL AND (post.pinned_until IS NULL OR post.pinned_until > now()) THEN post.pin_order END ASC,
+      CASE WHEN $7::bool THEN post.created_at END ASC,
+      CASE WHEN $8::bool THEN post.created_at END DESC,
+      CASE WHEN $9::bool THEN likes.count END ASC
 LIMIT $2 OFFSET $3
 `
 
 type GetPostsParams struct {
-	Author   uuid.UUID `json:"author"`
-	Limit    int32     `json:"limit"`
-	Offset   int32     `json:"offset"`
-	Search   string    `json:"search"`
-	DateAsc  bool      `json:"dateAsc"`
-	DateDesc bool      `json:"dateDesc"`
-	TopAsc   bool      `json:"topAsc"`
+	Author   uuid.UUID   `json:"author"`
+	Limit    int32       `json:"limit"`
+	Offset   int32       `json:"offset"`
+	Archived bool        `json:"archived"`
+	Board    pgtype.Int4 `json:"board"`
+	Search   string      `json:"search"`
+	DateAsc  bool        `json:"dateAsc"`
+	DateDesc bool        `json:"dateDesc"`
+	TopAsc   bool        `json:"topAsc"`
+}
+
+type GetPostsRow struct {
+	ID            int32              `json:"id"`
+	Author        uuid.UUID          `json:"author"`
+	Board         string             `json:"board"`
+	CreatedAt     pgtype.Timestamptz `json:"createdAt"`
+	EditedAt      pgtype.Timestamptz `json:"editedAt"`
+	Content       string             `json:"content"`
+	ContentHtml   string             `json:"contentHtml"`
+	Attachment    json.RawMessage    `json:"attachment"`
+	Poll          json.RawMessage    `json:"poll"`
+	Name          pgtype.Text        `json:"name"`
+	Tripcode      pgtype.Text        `json:"tripcode"`
+	LockedAt      pgtype.Timestamptz `json:"lockedAt"`
+	ArchivedAt    pgtype.Timestamptz `json:"archivedAt"`
+	PinnedUntil   pgtype.Timestamptz `json:"pinnedUntil"`
+	IsPinned      bool               `json:"isPinned"`
+	LikesCount    int64              `json:"likesCount"`
+	CommentsCount int64              `json:"commentsCount"`
+	IsLiked       bool               `json:"isLiked"`
+}
+
+func (q *Queries) GetPosts(ctx context.Context, arg GetPostsParams) ([]GetPostsRow, error) {
+	rows, err := q.db.Query(ctx, getPosts,
+		arg.Author,
+		arg.Limit,
+		arg.Offset,
+		arg.Archived,
+		arg.Board,
+		arg.Search,
+		arg.DateAsc,
+		arg.DateDesc,
+		arg.TopAsc,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetPostsRow
+	for rows.Next() {
+		var i GetPostsRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.Author,
+			&i.Board,
+			&i.CreatedAt,
+			&i.EditedAt,
+			&i.Content,
+			&i.ContentHtml,
+			&i.Attachment,
+			&i.Poll,
+			&i.Name,
+			&i.Tripcode,
+			&i.LockedAt,
+			&i.ArchivedAt,
+			&i.PinnedUntil,
+			&i.IsPinned,
+			&i.LikesCount,
+			&i.CommentsCount,
+			&i.IsLiked,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRefTargets = `-- name: GetRefTargets :many
+SELECT comment.id, comment.post FROM comment
+JOIN post ON post.id = comment.post
+JOIN author ON author.id = comment.author
+WHERE comment.id = ANY ($1::int[]) AND comment.id != $2::int
+    AND comment.deleted_at IS NULL AND post.deleted_at IS NULL
+    AND (comment.author = $3 OR (comment.visibility = 'visible' AND NOT author.shadowbanned))
+`
+
+type GetRefTargetsParams struct {
+	Ids    []int32   `json:"ids"`
+	Self   int32     `json:"self"`
+	Author uuid.UUID `json:"author"`
+}
+
+type GetRefTargetsRow struct {
+	ID   int32 `json:"id"`
+	Post int32 `json:"post"`
+}
+
+// Referenced comments that exist and the author can see, a comment can't reference itself
+func (q *Queries) GetRefTargets(ctx context.Context, arg GetRefTargetsParams) ([]GetRefTargetsRow, error) {
+	rows, err := q.db.Query(ctx, getRefTargets, arg.Ids, arg.Self, arg.Author)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRefTargetsRow
+	for rows.Next() {
+		var i GetRefTargetsRow
+		if err := rows.Scan(&i.ID, &i.Post); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRefreshToken = `-- name: GetRefreshToken :one
+SELECT id, family, author, token_hash, created_at, expires_at, used_at, revoked_at FROM refresh_token
+WHERE token_hash = $1
+`
+
+func (q *Queries) GetRefreshToken(ctx context.Context, tokenHash []byte) (RefreshToken, error) {
+	row := q.db.QueryRow(ctx, getRefreshToken, tokenHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.ID,
+		&i.Family,
+		&i.Author,
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.UsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const getRevokedAuthors = `-- name: GetRevokedAuthors :many
+SELECT author, revoked_at FROM revoked_author
+`
+
+func (q *Queries) GetRevokedAuthors(ctx context.Context) ([]RevokedAuthor, error) {
+	rows, err := q.db.Query(ctx, getRevokedAuthors)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RevokedAuthor
+	for rows.Next() {
+		var i RevokedAuthor
+		if err := rows.Scan(&i.Author, &i.RevokedAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRevokedFamilies = `-- name: GetRevokedFamilies :many
+SELECT family, expires_at FROM revoked_family
+WHERE expires_at > now()
+`
+
+type GetRevokedFamiliesRow struct {
+	Family    uuid.UUID          `json:"family"`
+	ExpiresAt pgtype.Timestamptz `json:"expiresAt"`
 }
 
-type GetPostsRow struct {
-	ID            int32              `json:"id"`
-	Author        uuid.UUID          `json:"author"`
-	CreatedAt     pgtype.Timestamptz `json:"createdAt"`
-	Content       string             `json:"content"`
-	LikesCount    int64              `json:"likesCount"`
-	CommentsCount int64              `json:"commentsCount"`
-	IsLiked       bool               `json:"isLiked"`
+func (q *Queries) GetRevokedFamilies(ctx context.Context) ([]GetRevokedFamiliesRow, error) {
+	rows, err := q.db.Query(ctx, getRevokedFamilies)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRevokedFamiliesRow
+	for rows.Next() {
+		var i GetRevokedFamiliesRow
+		if err := rows.Scan(&i.Family, &i.ExpiresAt); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-func (q *Queries) GetPosts(ctx context.Context, arg GetPostsParams) ([]GetPostsRow, error) {
-	rows, err := q.db.Query(ctx, getPosts,
-		arg.Author,
-		arg.Limit,
-		arg.Offset,
-		arg.Search,
-		arg.DateAsc,
-		arg.DateDesc,
-		arg.TopAsc,
-	)
+const getRevokedTokens = `-- name: GetRevokedTokens :many
+SELECT jti, expires_at FROM revoked_token
+WHERE expires_at > now()
+`
+
+type GetRevokedTokensRow struct {
+	Jti       uuid.UUID          `json:"jti"`
+	ExpiresAt pgtype.Timestamptz `json:"expiresAt"`
+}
+
+func (q *Queries) GetRevokedTokens(ctx context.Context) ([]GetRevokedTokensRow, error) {
+	rows, err := q.db.Query(ctx, getRevokedTokens)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []GetPostsRow
+	var items []GetRevokedTokensRow
 	for rows.Next() {
-		var i GetPostsRow
-		if err := rows.Scan(
-			&i.ID,
-			&i.Author,
-			&i.CreatedAt,
-			&i.Content,
-			&i.LikesCount,
-			&i.CommentsCount,
-			&i.IsLiked,
-		); err != nil {
+		var i GetRevokedTokensRow
+		if err := rows.Scan(&i.Jti, &i.ExpiresAt); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -404,6 +2008,87 @@ func (q *Queries) GetPosts(ctx context.Context, arg GetPostsParams) ([]GetPostsR
 	return items, nil
 }
 
+const hasNearDuplicate = `-- name: HasNearDuplicate :one
+SELECT EXISTS (
+    SELECT 1 FROM post
+    WHERE post.created_at > $1::timestamptz AND post.fingerprint IS NOT NULL AND post.id != $2::int
+        AND bit_count((post.fingerprint # $3::bigint)::bit(64)) <= $4::int
+) OR EXISTS (
+    SELECT 1 FROM comment
+    WHERE comment.created_at > $1::timestamptz AND comment.fingerprint IS NOT NULL AND comment.id != $5::int
+        AND bit_count((comment.fingerprint # $3::bigint)::bit(64)) <= $4::int
+) as "duplicate"
+`
+
+type HasNearDuplicateParams struct {
+	Since          pgtype.Timestamptz `json:"since"`
+	ExcludePost    int32              `json:"excludePost"`
+	Fingerprint    int64              `json:"fingerprint"`
+	MaxDistance    int32              `json:"maxDistance"`
+	ExcludeComment int32              `json:"excludeComment"`
+}
+
+func (q *Queries) HasNearDuplicate(ctx context.Context, arg HasNearDuplicateParams) (bool, error) {
+	row := q.db.QueryRow(ctx, hasNearDuplicate,
+		arg.Since,
+		arg.ExcludePost,
+		arg.Fingerprint,
+		arg.MaxDistance,
+		arg.ExcludeComment,
+	)
+	var duplicate bool
+	err := row.Scan(&duplicate)
+	return duplicate, err
+}
+
+const isFileServed = `-- name: IsFileServed :one
+SELECT EXISTS (
+    SELECT 1 FROM attachment
+    WHERE (attachment.file_key = $1 OR attachment.thumb_key = $1)
+        AND (
+            EXISTS (SELECT 1 FROM post WHERE post.attachment = attachment.hash AND post.deleted_at IS NULL)
+            OR EXISTS (SELECT 1 FROM comment WHERE comment.attachment = attachment.hash AND comment.deleted_at IS NULL)
+        )
+) as "served"
+`
+
+// Files of attachments whose posts and comments are all deleted are not served
+func (q *Queries) IsFileServed(ctx context.Context, key string) (bool, error) {
+	row := q.db.QueryRow(ctx, isFileServed, key)
+	var served bool
+	err := row.Scan(&served)
+	return served, err
+}
+
+const liftBan = `-- name: LiftBan :one
+UPDATE ban
+SET lifted_at = now(), lifted_by = $2
+WHERE id = $1 AND lifted_at IS NULL
+RETURNING id, author, network, reason, expires_at, created_by, created_at, lifted_at, lifted_by
+`
+
+type LiftBanParams struct {
+	ID       int32       `json:"id"`
+	LiftedBy pgtype.UUID `json:"liftedBy"`
+}
+
+func (q *Queries) LiftBan(ctx context.Context, arg LiftBanParams) (Ban, error) {
+	row := q.db.QueryRow(ctx, liftBan, arg.ID, arg.LiftedBy)
+	var i Ban
+	err := row.Scan(
+		&i.ID,
+		&i.Author,
+		&i.Network,
+		&i.Reason,
+		&i.ExpiresAt,
+		&i.CreatedBy,
+		&i.CreatedAt,
+		&i.LiftedAt,
+		&i.LiftedBy,
+	)
+	return i, err
+}
+
 const likeComment = `-- name: LikeComment :one
 INSERT INTO comment_like (author, comment)
 VALUES ($1, $2)
@@ -440,6 +2125,351 @@ func (q *Queries) LikePost(ctx context.Context, arg LikePostParams) (PostLike, e
 	return i, err
 }
 
+const lockPost = `-- name: LockPost :execrows
+UPDATE post
+SET locked_at = now(), locked_by = $2
+WHERE id = $1 AND locked_at IS NULL
+`
+
+type LockPostParams struct {
+	ID       int32       `json:"id"`
+	LockedBy pgtype.UUID `json:"lockedBy"`
+}
+
+func (q *Queries) LockPost(ctx context.Context, arg LockPostParams) (int64, error) {
+	result, err := q.db.Exec(ctx, lockPost, arg.ID, arg.LockedBy)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const pinPost = `-- name: PinPost :execrows
+UPDATE post
+SET pinned_at = now(), pinned_by = $2, pinned_until = $3, pin_order = $4
+WHERE id = $1 AND deleted_at IS NULL AND archived_at IS NULL
+`
+
+type PinPostParams struct {
+	ID          int32              `json:"id"`
+	PinnedBy    pgtype.UUID        `json:"pinnedBy"`
+	PinnedUntil pgtype.Timestamptz `json:"pinnedUntil"`
+	PinOrder    int32              `json:"pinOrder"`
+}
+
+func (q *Queries) PinPost(ctx context.Context, arg PinPostParams) (int64, error) {
+	result, err := q.db.Exec(ctx, pinPost,
+		arg.ID,
+		arg.PinnedBy,
+		arg.PinnedUntil,
+		arg.PinOrder,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeDeletedComments = `-- name: PurgeDeletedComments :execrows
+DELETE FROM comment
+WHERE deleted_at < $1::timestamptz
+    AND NOT EXISTS (SELECT 1 FROM comment as reply WHERE reply.reply = comment.id)
+    AND NOT EXISTS (SELECT 1 FROM comment_ref WHERE comment_ref.target = comment.id)
+`
+
+// Quoted ones stay as tombstones, so quotes and backlinks still resolve
+func (q *Queries) PurgeDeletedComments(ctx context.Context, deletedBefore pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeDeletedComments, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeDeletedPosts = `-- name: PurgeDeletedPosts :execrows
+DELETE FROM post
+WHERE deleted_at < $1::timestamptz
+    AND NOT EXISTS (SELECT 1 FROM comment WHERE comment.post = post.id AND comment.deleted_at IS NULL)
+    AND NOT EXISTS (
+        SELECT 1 FROM comment_ref
+        JOIN comment as target ON target.id = comment_ref.target
+        JOIN comment as source ON source.id = comment_ref.comment
+        WHERE target.post = post.id AND source.post != post.id
+    )
+`
+
+// Comments go with the post, so it stays while comments of other posts quote them
+func (q *Queries) PurgeDeletedPosts(ctx context.Context, deletedBefore pgtype.Timestamptz) (int64, error) {
+	result, err := q.db.Exec(ctx, purgeDeletedPosts, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const purgeUnusedAttachments = `-- name: PurgeUnusedAttachments :many
+DELETE FROM attachment
+WHERE created_at < $1::timestamptz
+    AND NOT EXISTS (SELECT 1 FROM post WHERE post.attachment = attachment.hash)
+    AND NOT EXISTS (SELECT 1 FROM comment WHERE comment.attachment = attachment.hash)
+RETURNING hash, content_type, size, width, height, file_key, thumb_key, thumb_width, thumb_height, created_at
+`
+
+// Recent ones may be about to get their post or comment
+func (q *Queries) PurgeUnusedAttachments(ctx context.Context, createdBefore pgtype.Timestamptz) ([]Attachment, error) {
+	rows, err := q.db.Query(ctx, purgeUnusedAttachments, createdBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Attachment
+	for rows.Next() {
+		var i Attachment
+		if err := rows.Scan(
+			&i.Hash,
+			&i.ContentType,
+			&i.Size,
+			&i.Width,
+			&i.Height,
+			&i.FileKey,
+			&i.ThumbKey,
+			&i.ThumbWidth,
+			&i.ThumbHeight,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const resolveReports = `-- name: ResolveReports :execrows
+UPDATE report
+SET resolved_at = now(), resolved_by = $1, resolution = $2
+WHERE resolved_at IS NULL AND (post = $3 OR comment = $4)
+`
+
+type ResolveReportsParams struct {
+	ResolvedBy pgtype.UUID `json:"resolvedBy"`
+	Resolution pgtype.Text `json:"resolution"`
+	Post       pgtype.Int4 `json:"post"`
+	Comment    pgtype.Int4 `json:"comment"`
+}
+
+func (q *Queries) ResolveReports(ctx context.Context, arg ResolveReportsParams) (int64, error) {
+	result, err := q.db.Exec(ctx, resolveReports,
+		arg.ResolvedBy,
+		arg.Resolution,
+		arg.Post,
+		arg.Comment,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const restoreComment = `-- name: RestoreComment :execrows
+UPDATE comment
+SET deleted_at = NULL, deleted_by = NULL
+WHERE id = $1 AND deleted_at IS NOT NULL
+`
+
+func (q *Queries) RestoreComment(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, restoreComment, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const restorePost = `-- name: RestorePost :execrows
+UPDATE post
+SET deleted_at = NULL, deleted_by = NULL
+WHERE id = $1 AND deleted_at IS NOT NULL
+`
+
+func (q *Queries) RestorePost(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, restorePost, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const revokeAuthorRefreshTokens = `-- name: RevokeAuthorRefreshTokens :exec
+UPDATE refresh_token
+SET revoked_at = now()
+WHERE author = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeAuthorRefreshTokens(ctx context.Context, author uuid.UUID) error {
+	_, err := q.db.Exec(ctx, revokeAuthorRefreshTokens, author)
+	return err
+}
+
+const revokeAuthorTokens = `-- name: RevokeAuthorTokens :one
+INSERT INTO revoked_author (author)
+VALUES ($1)
+ON CONFLICT (author)
+DO UPDATE SET
+    revoked_at = now()
+RETURNING author, revoked_at
+`
+
+func (q *Queries) RevokeAuthorTokens(ctx context.Context, author uuid.UUID) (RevokedAuthor, error) {
+	row := q.db.QueryRow(ctx, revokeAuthorTokens, author)
+	var i RevokedAuthor
+	err := row.Scan(&i.Author, &i.RevokedAt)
+	return i, err
+}
+
+const revokeFamily = `-- name: RevokeFamily :exec
+INSERT INTO revoked_family (family, expires_at)
+VALUES ($1, $2)
+ON CONFLICT (family) DO NOTHING
+`
+
+type RevokeFamilyParams struct {
+	Family    uuid.UUID          `json:"family"`
+	ExpiresAt pgtype.Timestamptz `json:"expiresAt"`
+}
+
+func (q *Queries) RevokeFamily(ctx context.Context, arg RevokeFamilyParams) error {
+	_, err := q.db.Exec(ctx, revokeFamily, arg.Family, arg.ExpiresAt)
+	return err
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_token
+SET revoked_at = now()
+WHERE family = $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, family uuid.UUID) error {
+	_, err := q.db.Exec(ctx, revokeRefreshTokenFamily, family)
+	return err
+}
+
+const revokeToken = `-- name: RevokeToken :exec
+INSERT INTO revoked_token (jti, author, expires_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (jti) DO NOTHING
+`
+
+type RevokeTokenParams struct {
+	Jti       uuid.UUID          `json:"jti"`
+	Author    uuid.UUID          `json:"author"`
+	ExpiresAt pgtype.Timestamptz `json:"expiresAt"`
+}
+
+func (q *Queries) RevokeToken(ctx context.Context, arg RevokeTokenParams) error {
+	_, err := q.db.Exec(ctx, revokeToken, arg.Jti, arg.Author, arg.ExpiresAt)
+	return err
+}
+
+const setAuthorRole = `-- name: SetAuthorRole :one
+UPDATE author
+SET role = $2
+WHERE id = $1
+RETURNING id, ip, role, shadowbanned
+`
+
+type SetAuthorRoleParams struct {
+	ID   uuid.UUID `json:"id"`
+	Role string    `json:"role"`
+}
+
+func (q *Queries) SetAuthorRole(ctx context.Context, arg SetAuthorRoleParams) (Author, error) {
+	row := q.db.QueryRow(ctx, setAuthorRole, arg.ID, arg.Role)
+	var i Author
+	err := row.Scan(
+		&i.ID,
+		&i.Ip,
+		&i.Role,
+		&i.Shadowbanned,
+	)
+	return i, err
+}
+
+const setAuthorShadowban = `-- name: SetAuthorShadowban :one
+UPDATE author
+SET shadowbanned = $2
+WHERE id = $1
+RETURNING id, ip, role, shadowbanned
+`
+
+type SetAuthorShadowbanParams struct {
+	ID           uuid.UUID `json:"id"`
+	Shadowbanned bool      `json:"shadowbanned"`
+}
+
+func (q *Queries) SetAuthorShadowban(ctx context.Context, arg SetAuthorShadowbanParams) (Author, error) {
+	row := q.db.QueryRow(ctx, setAuthorShadowban, arg.ID, arg.Shadowbanned)
+	var i Author
+	err := row.Scan(
+		&i.ID,
+		&i.Ip,
+		&i.Role,
+		&i.Shadowbanned,
+	)
+	return i, err
+}
+
+const setBoard = `-- name: SetBoard :one
+INSERT INTO board (slug, title, description, settings)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (slug)
+DO UPDATE SET title = excluded.title, description = excluded.description, settings = excluded.settings
+RETURNING id, slug, title, description, settings, created_at
+`
+
+type SetBoardParams struct {
+	Slug        string          `json:"slug"`
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Settings    json.RawMessage `json:"settings"`
+}
+
+func (q *Queries) SetBoard(ctx context.Context, arg SetBoardParams) (Board, error) {
+	row := q.db.QueryRow(ctx, setBoard,
+		arg.Slug,
+		arg.Title,
+		arg.Description,
+		arg.Settings,
+	)
+	var i Board
+	err := row.Scan(
+		&i.ID,
+		&i.Slug,
+		&i.Title,
+		&i.Description,
+		&i.Settings,
+		&i.CreatedAt,
+	)
+	return i, err
+}
+
+const touchDeviceKey = `-- name: TouchDeviceKey :exec
+UPDATE device_key
+SET last_used_at = now(), last_ip = $2
+WHERE id = $1
+`
+
+type TouchDeviceKeyParams struct {
+	ID     int32       `json:"id"`
+	LastIp *netip.Addr `json:"lastIp"`
+}
+
+func (q *Queries) TouchDeviceKey(ctx context.Context, arg TouchDeviceKeyParams) error {
+	_, err := q.db.Exec(ctx, touchDeviceKey, arg.ID, arg.LastIp)
+	return err
+}
+
 const unlikeComment = `-- name: UnlikeComment :exec
 DELETE FROM comment_like
 WHERE comment = $1 AND author = $2
@@ -469,3 +2499,86 @@ func (q *Queries) UnlikePost(ctx context.Context, arg UnlikePostParams) error {
 	_, err := q.db.Exec(ctx, unlikePost, arg.Post, arg.Author)
 	return err
 }
+
+const unlockPost = `-- name: UnlockPost :execrows
+UPDATE post
+SET locked_at = NULL, locked_by = NULL
+WHERE id = $1 AND locked_at IS NOT NULL
+`
+
+func (q *Queries) UnlockPost(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, unlockPost, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const unpinPost = `-- name: UnpinPost :execrows
+UPDATE post
+SET pinned_at = NULL, pinned_by = NULL, pinned_until = NULL, pin_order = 0
+WHERE id = $1 AND pinned_at IS NOT NULL
+`
+
+func (q *Queries) UnpinPost(ctx context.Context, id int32) (int64, error) {
+	result, err := q.db.Exec(ctx, unpinPost, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
+
+const useRefreshToken = `-- name: UseRefreshToken :one
+UPDATE refresh_token
+SET used_at = now()
+WHERE token_hash = $1 AND used_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+RETURNING id, family, author, token_hash, created_at, expires_at, used_at, revoked_at
+`
+
+func (q *Queries) UseRefreshToken(ctx context.Context, tokenHash []byte) (RefreshToken, error) {
+	row := q.db.QueryRow(ctx, useRefreshToken, tokenHash)
+	var i RefreshToken
+	err := row.Scan(
+		&i.ID,
+		&i.Family,
+		&i.Author,
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.ExpiresAt,
+		&i.UsedAt,
+		&i.RevokedAt,
+	)
+	return i, err
+}
+
+const votePoll = `-- name: VotePoll :one
+INSERT INTO poll_vote (poll, author, options)
+SELECT poll.post, $1::uuid, $2::smallint[] FROM poll
+JOIN post ON post.id = poll.post
+WHERE poll.post = $3
+    AND post.deleted_at IS NULL
+    AND post.archived_at IS NULL
+    AND (poll.closes_at IS NULL OR poll.closes_at > now())
+    AND (poll.multiple OR cardinality($2::smallint[]) = 1)
+ON CONFLICT DO NOTHING
+RETURNING poll, author, options, created_at
+`
+
+type VotePollParams struct {
+	Author  uuid.UUID `json:"author"`
+	Options []int16   `json:"options"`
+	Poll    int32     `json:"poll"`
+}
+
+// Inserts nothing when the poll is closed, the vote does not fit it or the author already voted
+func (q *Queries) VotePoll(ctx context.Context, arg VotePollParams) (PollVote, error) {
+	row := q.db.QueryRow(ctx, votePoll, arg.Author, arg.Options, arg.Poll)
+	var i PollVote
+	err := row.Scan(
+		&i.Poll,
+		&i.Author,
+		&i.Options,
+		&i.CreatedAt,
+	)
+	return i, err
+}